@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Assembler stitches the records of one kernel event (SYSCALL, EXECVE, CWD,
+// PATH, PROCTITLE, ...), which share the same msg=audit(ts:serial), back into
+// a single event. A group is emitted when its EOE record arrives or once no
+// record has joined it for the timeout, which covers single-record events
+// that never get an EOE.
+type Assembler struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	emit    func(Event)
+	groups  map[string]*group
+	n       uint64
+}
+
+type group struct {
+	n       uint64 // arrival order
+	last    time.Time
+	records []Event
+}
+
+// NewAssembler returns an assembler that passes events through untouched
+// when timeout is zero.
+func NewAssembler(timeout time.Duration, emit func(Event)) *Assembler {
+	return &Assembler{timeout: timeout, emit: emit, groups: make(map[string]*group)}
+}
+
+func (a *Assembler) Add(ev Event) {
+	if a.timeout <= 0 || ev.TimeSource != "record" {
+		a.emit(ev)
+		return
+	}
+	// serials are only unique per host
+	id := fmt.Sprintf("%s/%s/%d", ev.Node, ev.Timestamp, ev.Serial)
+	a.mu.Lock()
+	g, ok := a.groups[id]
+	if !ok {
+		a.n++
+		g = &group{n: a.n}
+		a.groups[id] = g
+	}
+	g.last = time.Now()
+	g.records = append(g.records, ev)
+	if ev.Type == "EOE" {
+		delete(a.groups, id)
+	} else {
+		g = nil
+	}
+	a.mu.Unlock()
+
+	if g != nil {
+		a.emit(compose(g.records))
+	}
+}
+
+// Run emits groups that have gone quiet until ctx is done.
+func (a *Assembler) Run(ctx context.Context) {
+	if a.timeout <= 0 {
+		return
+	}
+	t := time.NewTicker(a.timeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			a.expire(func(g *group) bool { return now.Sub(g.last) >= a.timeout })
+		}
+	}
+}
+
+// Flush emits every open group regardless of age.
+func (a *Assembler) Flush() {
+	a.expire(func(*group) bool { return true })
+}
+
+func (a *Assembler) expire(done func(*group) bool) {
+	a.mu.Lock()
+	var out []*group
+	for id, g := range a.groups {
+		if done(g) {
+			out = append(out, g)
+			delete(a.groups, id)
+		}
+	}
+	a.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].n < out[j].n })
+	for _, g := range out {
+		a.emit(compose(g.records))
+	}
+}
+
+// compose builds the composite event for a group. The EOE marker is dropped
+// from the children but its checkpoint sequence is kept; a lone record is
+// emitted as itself.
+func compose(records []Event) Event {
+	var seqs []uint64
+	children := make([]Event, 0, len(records))
+	for _, r := range records {
+		seqs = append(seqs, r.seqs...)
+		if r.Type != "EOE" {
+			r.seqs = nil
+			children = append(children, r)
+		}
+	}
+	switch len(children) {
+	case 0:
+		records[0].seqs = seqs
+		return records[0]
+	case 1:
+		children[0].seqs = seqs
+		return children[0]
+	}
+	ev := Event{
+		Type:       children[0].Type,
+		Node:       children[0].Node,
+		Timestamp:  children[0].Timestamp,
+		TimeSource: children[0].TimeSource,
+		Serial:     children[0].Serial,
+		Historical: children[0].Historical,
+		Records:    children,
+		seqs:       seqs,
+	}
+	if argv, ok := mergeExecve(children); ok {
+		ev.Argv, ev.Cmdline = argv, commandLine(argv)
+	}
+	for _, c := range children {
+		if c.Sockaddr != nil {
+			ev.Sockaddr = c.Sockaddr
+			break
+		}
+	}
+	return ev
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collect gathers what an assembler emits.
+type collect struct {
+	mu  sync.Mutex
+	evs []Event
+}
+
+func (c *collect) emit(ev Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evs = append(c.evs, ev)
+}
+
+func (c *collect) events() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.evs...)
+}
+
+func record(t *testing.T, line string, seq uint64) Event {
+	t.Helper()
+	ev, ok := parseLine(line)
+	if !ok {
+		t.Fatalf("parseLine(%q) failed", line)
+	}
+	ev.seqs = []uint64{seq}
+	return ev
+}
+
+func TestAssemblerEOE(t *testing.T) {
+	var c collect
+	a := NewAssembler(time.Hour, c.emit)
+	a.Add(record(t, `type=SYSCALL msg=audit(1700000000.123:42): arch=c000003e syscall=59 key="collector"`, 1))
+	a.Add(record(t, `type=SYSCALL msg=audit(1700000000.124:43): arch=c000003e syscall=257`, 2))
+	a.Add(record(t, `type=EXECVE msg=audit(1700000000.123:42): argc=2 a0="ls" a1="-l"`, 3))
+	a.Add(record(t, `type=PATH msg=audit(1700000000.123:42): item=0 name="/usr/bin/ls"`, 4))
+	if evs := c.events(); len(evs) != 0 {
+		t.Fatalf("emitted %d events before EOE", len(evs))
+	}
+	a.Add(record(t, `type=EOE msg=audit(1700000000.123:42):`, 5))
+
+	evs := c.events()
+	if len(evs) != 1 {
+		t.Fatalf("emitted %d events, want 1", len(evs))
+	}
+	ev := evs[0]
+	if ev.Type != "SYSCALL" || ev.Serial != 42 || len(ev.Records) != 3 {
+		t.Fatalf("event = %s serial %d with %d records, want SYSCALL 42 with 3", ev.Type, ev.Serial, len(ev.Records))
+	}
+	if ev.Cmdline != "ls -l" {
+		t.Errorf("cmdline = %q", ev.Cmdline)
+	}
+	if fmt.Sprint(ev.seqs) != "[1 3 4 5]" {
+		t.Errorf("seqs = %v, want the EOE's included", ev.seqs)
+	}
+	for _, r := range ev.Records {
+		if r.seqs != nil {
+			t.Errorf("%s record kept seqs %v", r.Type, r.seqs)
+		}
+	}
+
+	// the other group is still open until flushed
+	a.Flush()
+	if evs = c.events(); len(evs) != 2 || evs[1].Serial != 43 || evs[1].Records != nil {
+		t.Errorf("after flush: %+v", evs)
+	}
+}
+
+func TestAssemblerTimeout(t *testing.T) {
+	var c collect
+	a := NewAssembler(20*time.Millisecond, c.emit)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx)
+
+	a.Add(record(t, `type=USER_LOGIN msg=audit(1700000000.123:7): pid=1 res=success`, 1))
+	a.Add(record(t, `node=web1 type=USER_LOGIN msg=audit(1700000000.123:7): pid=1 res=success`, 2))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.events()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	evs := c.events()
+	if len(evs) != 2 {
+		t.Fatalf("emitted %d events after the timeout, want 2", len(evs))
+	}
+	// the same serial on another node is another event, emitted in order
+	if evs[0].Node != "" || evs[1].Node != "web1" {
+		t.Errorf("nodes = %q, %q", evs[0].Node, evs[1].Node)
+	}
+}
+
+func TestAssemblerPassThrough(t *testing.T) {
+	var c collect
+	a := NewAssembler(0, c.emit)
+	a.Add(record(t, `type=SYSCALL msg=audit(1700000000.123:42): syscall=59`, 1))
+	if len(c.events()) != 1 {
+		t.Error("grouping disabled but the record was held")
+	}
+
+	// records without a timestamp cannot be matched up
+	a = NewAssembler(time.Hour, c.emit)
+	a.Add(record(t, `type=DAEMON_START msg=audit(1700000000:1): op=start`, 2))
+	if len(c.events()) != 2 {
+		t.Error("record without an audit id was held")
+	}
+}
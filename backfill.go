@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// backfill ships the rotated audit logs, oldest first, and then the live log
+// as it stands now through emit, marking every event historical. emit also
+// gets the position just past the event's line, or nil for compressed logs,
+// which the tailer cannot resume from. backfill returns the position it
+// reached in the live log so tailing can carry on from there.
+func backfill(path string, emit func(Event, *Checkpoint)) (Checkpoint, error) {
+	names, err := rotatedLogs(path)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	for _, name := range names {
+		n, err := backfillFile(name, emit)
+		if err != nil {
+			log.Printf("backfill %s: %v", name, err)
+			continue
+		}
+		log.Printf("backfill: %s (%d events)", name, n)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	dev, ino := fileID(fi)
+	n, off, err := backfillReader(io.LimitReader(f, fi.Size()), true, positioned(dev, ino, emit))
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	log.Printf("backfill: %s (%d events)", path, n)
+	return Checkpoint{Device: dev, Inode: ino, Offset: off}, nil
+}
+
+// rotatedLogs returns the rotated copies of path, such as audit.log.1 or
+// audit.log.2.gz, oldest (highest numbered) first.
+func rotatedLogs(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	nums := make(map[string]int, len(matches))
+	var names []string
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, path+"."), ".gz")
+		n, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		nums[m] = n
+		names = append(names, m)
+	}
+	sort.Slice(names, func(i, j int) bool { return nums[names[i]] > nums[names[j]] })
+	return names, nil
+}
+
+func backfillFile(name string, emit func(Event, *Checkpoint)) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		n, _, err := backfillReader(zr, false, func(ev Event, _ int64) { emit(ev, nil) })
+		return n, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	dev, ino := fileID(fi)
+	n, _, err := backfillReader(f, false, positioned(dev, ino, emit))
+	return n, err
+}
+
+// positioned turns the offsets backfillReader reports into positions in the
+// file identified by dev and ino.
+func positioned(dev, ino uint64, emit func(Event, *Checkpoint)) func(Event, int64) {
+	return func(ev Event, off int64) {
+		emit(ev, &Checkpoint{Device: dev, Inode: ino, Offset: off, Serial: ev.Serial})
+	}
+}
+
+// backfillReader emits every line in r, with the byte offset just past it,
+// and returns the number of events and the offset just past the last line.
+// In the live log a trailing partial line may still be being written, so
+// it is left for the tailer; a rotated log's last line is as complete as
+// it will get.
+func backfillReader(r io.Reader, live bool, emit func(Event, int64)) (int, int64, error) {
+	var n int
+	var off int64
+	sc := bufio.NewScanner(r)
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		adv, tok, err := bufio.ScanLines(data, atEOF && !live)
+		off += int64(adv)
+		return adv, tok, err
+	})
+	for sc.Scan() {
+		if ev, ok := parseLine(sc.Text()); ok {
+			ev.Historical = true
+			emit(ev, off)
+			n++
+		}
+	}
+	return n, off, sc.Err()
+}
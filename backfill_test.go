@@ -0,0 +1,61 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackfill(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	line := func(serial string) string {
+		return "type=USER_LOGIN msg=audit(1700000000.123:" + serial + "): pid=1 res=success"
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// rotated logs end without a newline when auditd stopped mid-write
+	var gz strings.Builder
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(line("1") + "\n" + line("2")))
+	zw.Close()
+	write(path+".3.gz", gz.String())
+	write(path+".2", line("3")+"\n"+line("4"))
+	write(path+".1", line("5")+"\n")
+	live := line("6") + "\n"
+	write(path, live+"type=USER_LOGIN msg=audit(1700000000.123:7): pid=1 re")
+	write(path+".bak", line("99")+"\n")
+
+	var serials []uint64
+	var positioned int
+	end, err := backfill(path, func(ev Event, pos *Checkpoint) {
+		if !ev.Historical {
+			t.Errorf("event %d not marked historical", ev.Serial)
+		}
+		serials = append(serials, ev.Serial)
+		if pos != nil {
+			positioned++
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(serials); got != "[1 2 3 4 5 6]" {
+		t.Errorf("serials = %s, want [1 2 3 4 5 6]", got)
+	}
+	// only the compressed log has no positions to resume from
+	if positioned != 4 {
+		t.Errorf("%d events with positions, want 4", positioned)
+	}
+	// tailing picks up the partial line where backfill left it
+	if end.Offset != int64(len(live)) {
+		t.Errorf("end offset = %d, want %d", end.Offset, len(live))
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoint records how far into the audit log the collector has delivered.
+// Offset is the byte just past the last delivered line of the file identified
+// by Device and Inode.
+type Checkpoint struct {
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+	Serial uint64 `json:"serial"`
+}
+
+// loadCheckpoint returns nil without error when no checkpoint has been saved.
+func loadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (cp Checkpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// sync before the rename so a crash cannot leave an empty checkpoint
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkpointer hands out a sequence number for every line read and only moves
+// the saved checkpoint past a line once it, and every line before it, has
+// been acknowledged. Lines that fail delivery therefore hold the checkpoint
+// back and are replayed after a restart. The position is written out by Run
+// every checkpointInterval and by sync, not on every ack.
+type checkpointer struct {
+	mu      sync.Mutex
+	path    string
+	base    uint64 // sequence number of pending[0]
+	pending []pendingLine
+	pos     Checkpoint // past the last line acknowledged in order
+	dirty   bool       // pos has not been saved yet
+}
+
+const checkpointInterval = time.Second
+
+// maxPending bounds the lines tracked at once. A line whose ack never comes
+// would otherwise keep every later line in memory.
+const maxPending = 1 << 20
+
+type pendingLine struct {
+	pos  Checkpoint
+	done bool
+}
+
+// newCheckpointer returns nil when path is empty; a nil checkpointer accepts
+// and ignores every call.
+func newCheckpointer(path string) *checkpointer {
+	if path == "" {
+		return nil
+	}
+	return &checkpointer{path: path}
+}
+
+func (c *checkpointer) track(pos Checkpoint) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, pendingLine{pos: pos})
+	seq := c.base + uint64(len(c.pending)) - 1
+	if len(c.pending) > maxPending {
+		// give up on the oldest tenth so this does not happen on every line
+		n := 0
+		for i := range c.pending[:maxPending/10] {
+			if !c.pending[i].done {
+				c.pending[i].done = true
+				n++
+			}
+		}
+		log.Printf("checkpoint: %d lines never acknowledged; moving past them", n)
+		c.advance()
+	}
+	return seq
+}
+
+func (c *checkpointer) ack(seqs ...uint64) {
+	if c == nil || len(seqs) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, seq := range seqs {
+		if seq >= c.base && seq-c.base < uint64(len(c.pending)) {
+			c.pending[seq-c.base].done = true
+		}
+	}
+	c.advance()
+}
+
+// advance moves the position past the acknowledged lines at the front of
+// pending. The caller holds mu.
+func (c *checkpointer) advance() {
+	n := 0
+	for n < len(c.pending) && c.pending[n].done {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	c.pos = c.pending[n-1].pos
+	c.dirty = true
+	c.pending = c.pending[n:]
+	c.base += uint64(n)
+}
+
+// Run saves the position every checkpointInterval until ctx is done.
+func (c *checkpointer) Run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	t := time.NewTicker(checkpointInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			c.sync()
+		}
+	}
+}
+
+// sync saves the position if it moved since the last save.
+func (c *checkpointer) sync() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return
+	}
+	if err := c.pos.save(c.path); err != nil {
+		log.Printf("checkpoint save: %v", err)
+		return
+	}
+	c.dirty = false
+}
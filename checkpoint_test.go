@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointerOutOfOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	c := newCheckpointer(path)
+	var seqs []uint64
+	for i := range 4 {
+		seqs = append(seqs, c.track(Checkpoint{Inode: 7, Offset: int64(10 * (i + 1)), Serial: uint64(i + 1)}))
+	}
+
+	// later lines delivered first hold nothing up, but move nothing either
+	c.ack(seqs[1], seqs[3])
+	c.sync()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint saved before the first line was acknowledged: %v", err)
+	}
+
+	c.ack(seqs[0])
+	c.sync()
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Offset != 20 || cp.Serial != 2 {
+		t.Fatalf("checkpoint = %+v, want offset 20, serial 2", cp)
+	}
+
+	c.ack(seqs[2])
+	c.sync()
+	if cp, _ = loadCheckpoint(path); cp.Offset != 40 || cp.Inode != 7 {
+		t.Fatalf("checkpoint = %+v, want inode 7, offset 40", cp)
+	}
+	if len(c.pending) != 0 || c.base != 4 {
+		t.Errorf("%d lines pending from %d, want none from 4", len(c.pending), c.base)
+	}
+
+	// stale and unknown acks are ignored
+	c.ack(seqs[0], 99)
+	if next := c.track(Checkpoint{Offset: 50}); next != 4 {
+		t.Errorf("next seq = %d, want 4", next)
+	}
+}
+
+func TestCheckpointerSavesOnlyWhenMoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	c := newCheckpointer(path)
+	c.ack(c.track(Checkpoint{Offset: 10}))
+	c.sync()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	c.sync()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unchanged checkpoint saved again: %v", err)
+	}
+}
+
+func TestLoadCheckpointCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if cp, err := loadCheckpoint(path); cp != nil || err != nil {
+		t.Fatalf("missing checkpoint = %+v, %v; want nil, nil", cp, err)
+	}
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCheckpoint(path); err == nil {
+		t.Error("empty checkpoint loaded without error")
+	}
+}
+
+func TestNilCheckpointer(t *testing.T) {
+	var c *checkpointer
+	if seq := c.track(Checkpoint{}); seq != 0 {
+		t.Errorf("seq = %d", seq)
+	}
+	c.ack(1, 2)
+	c.sync()
+}
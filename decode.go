@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// encodedFields are the fields auditd treats as untrusted strings: it writes
+// them quoted when they are plain, and as bare hex when they contain spaces,
+// quotes or non-printable bytes. old and new are left out: most records,
+// CONFIG_CHANGE among them, use them for numbers that would pass for hex.
+var encodedFields = map[string]bool{
+	"acct": true, "cmd": true, "comm": true, "cwd": true, "data": true,
+	"dir": true, "exe": true, "file": true, "key": true, "name": true,
+	"ocomm": true, "path": true, "proctitle": true,
+	"root_dir": true, "sw": true, "vm": true, "watch": true,
+}
+
+// execveArg matches EXECVE argument fields, including the a1[0], a1[1], ...
+// chunks of a long argument.
+var execveArg = regexp.MustCompile(`^a\d+(\[\d+\])?$`)
+
+// decodeFields returns readable values for the hex-encoded fields of a
+// record of type typ, keyed like Fields. bare holds the keys whose values
+// were unquoted; quoted values are never encoded.
+func decodeFields(typ string, fields map[string]string, bare map[string]bool) map[string]string {
+	var decoded map[string]string
+	for k, v := range fields {
+		if !bare[k] || !(encodedFields[k] || typ == "EXECVE" && execveArg.MatchString(k)) {
+			continue
+		}
+		b, ok := decodeHex(v)
+		if !ok {
+			continue
+		}
+		s := string(b)
+		switch k {
+		case "proctitle":
+			// the arguments are separated by NULs
+			s = strings.ReplaceAll(strings.TrimRight(s, "\x00"), "\x00", " ")
+		case "key":
+			// several keys on one rule are joined with \x01
+			s = strings.ReplaceAll(s, "\x01", ",")
+		}
+		if decoded == nil {
+			decoded = make(map[string]string)
+		}
+		decoded[k] = s
+	}
+	return decoded
+}
+
+// decodeHex decodes an auditd hex string: an even number of upper-case hex
+// digits.
+func decodeHex(s string) ([]byte, bool) {
+	if s == "" || len(s)%2 != 0 {
+		return nil, false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'A' <= c && c <= 'F') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
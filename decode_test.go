@@ -0,0 +1,69 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestDecodeFields(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  string
+		line string
+		want map[string]string
+	}{
+		{
+			name: "path with spaces",
+			typ:  "PATH",
+			line: `type=PATH msg=audit(1700000000.123:42): item=0 name=2F746D702F6120622063 inode=131 dev=fd:00 mode=0100644`,
+			want: map[string]string{"name": "/tmp/a b c"},
+		},
+		{
+			name: "quoted values are plain",
+			typ:  "PATH",
+			line: `type=PATH msg=audit(1700000000.123:42): item=0 name="/etc/ABCD" inode=131`,
+		},
+		{
+			name: "proctitle",
+			typ:  "PROCTITLE",
+			line: `type=PROCTITLE msg=audit(1700000000.123:42): proctitle=6C73002D6C61002F746D70`,
+			want: map[string]string{"proctitle": "ls -la /tmp"},
+		},
+		{
+			name: "several keys",
+			typ:  "SYSCALL",
+			line: `type=SYSCALL msg=audit(1700000000.123:42): syscall=59 comm="ls" key=6578656301636F6C6C6563746F72`,
+			want: map[string]string{"key": "exec,collector"},
+		},
+		{
+			name: "execve arguments",
+			typ:  "EXECVE",
+			line: `type=EXECVE msg=audit(1700000000.123:42): argc=3 a0="echo" a1=68656C6C6F20776F726C64 a2[0]=4142`,
+			want: map[string]string{"a1": "hello world", "a2[0]": "AB"},
+		},
+		{
+			name: "argument fields outside EXECVE",
+			typ:  "SYSCALL",
+			line: `type=SYSCALL msg=audit(1700000000.123:42): syscall=42 a0=3 a1=7FFC1234 a2=10 a3=0`,
+		},
+		{
+			name: "config change numbers",
+			typ:  "CONFIG_CHANGE",
+			line: `type=CONFIG_CHANGE msg=audit(1700000000.123:42): op=set audit_backlog_limit=8192 old=64 auid=0 ses=1 res=1`,
+		},
+		{
+			name: "lower-case hex is not auditd's",
+			typ:  "PATH",
+			line: `type=PATH msg=audit(1700000000.123:42): item=0 name=abcd`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, bare := parseFields(tt.line)
+			got := decodeFields(tt.typ, fields, bare)
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("decodeFields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// execveArgv rebuilds argv from the fields of an EXECVE record, or of several
+// EXECVE records of the same event merged together, since the kernel spreads
+// very long command lines over more than one record. An argument too long
+// for a single field is split into a1[0], a1[1], ... chunks announced by
+// a1_len. Hex-encoded values are taken from decoded. It reports false if any
+// argument is missing.
+func execveArgv(fields, decoded map[string]string) ([]string, bool) {
+	argc, err := strconv.Atoi(fields["argc"])
+	if err != nil || argc < 0 {
+		return nil, false
+	}
+	value := func(k string) (string, bool) {
+		if v, ok := decoded[k]; ok {
+			return v, true
+		}
+		v, ok := fields[k]
+		return v, ok
+	}
+	argv := make([]string, 0, argc)
+	for i := range argc {
+		if v, ok := value(fmt.Sprintf("a%d", i)); ok {
+			argv = append(argv, v)
+			continue
+		}
+		if _, ok := fields[fmt.Sprintf("a%d_len", i)]; !ok {
+			return nil, false
+		}
+		var b strings.Builder
+		for j := 0; ; j++ {
+			v, ok := value(fmt.Sprintf("a%d[%d]", i, j))
+			if !ok {
+				if j == 0 {
+					return nil, false
+				}
+				break
+			}
+			b.WriteString(v)
+		}
+		argv = append(argv, b.String())
+	}
+	return argv, true
+}
+
+// commandLine joins argv the way a shell would need it typed, single-quoting
+// arguments that are empty or contain whitespace or quotes.
+func commandLine(argv []string) string {
+	args := make([]string, len(argv))
+	for i, a := range argv {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$`") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		args[i] = a
+	}
+	return strings.Join(args, " ")
+}
+
+// mergeExecve rebuilds argv across the EXECVE records among children.
+func mergeExecve(children []Event) ([]string, bool) {
+	fields := make(map[string]string)
+	decoded := make(map[string]string)
+	found := false
+	for _, c := range children {
+		if c.Type != "EXECVE" {
+			continue
+		}
+		found = true
+		maps.Copy(fields, c.Fields)
+		maps.Copy(decoded, c.Decoded)
+	}
+	if !found {
+		return nil, false
+	}
+	return execveArgv(fields, decoded)
+}
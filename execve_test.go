@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExecveArgv(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want []string // nil when argv cannot be rebuilt
+	}{
+		{
+			name: "plain",
+			line: `type=EXECVE msg=audit(1700000000.123:42): argc=3 a0="ls" a1="-l" a2="/tmp"`,
+			want: []string{"ls", "-l", "/tmp"},
+		},
+		{
+			name: "hex argument",
+			line: `type=EXECVE msg=audit(1700000000.123:42): argc=2 a0="cat" a1=2F746D702F612062`,
+			want: []string{"cat", "/tmp/a b"},
+		},
+		{
+			name: "chunked argument",
+			line: `type=EXECVE msg=audit(1700000000.123:42): argc=2 a0="echo" a1_len=10 a1[0]="hello" a1[1]=776F726C64`,
+			want: []string{"echo", "helloworld"},
+		},
+		{
+			name: "empty argument",
+			line: `type=EXECVE msg=audit(1700000000.123:42): argc=2 a0="touch" a1=""`,
+			want: []string{"touch", ""},
+		},
+		{
+			name: "missing argument",
+			line: `type=EXECVE msg=audit(1700000000.123:42): argc=3 a0="ls" a1="-l"`,
+		},
+		{
+			name: "chunks missing",
+			line: `type=EXECVE msg=audit(1700000000.123:42): argc=2 a0="echo" a1_len=10`,
+		},
+		{
+			name: "no argc",
+			line: `type=EXECVE msg=audit(1700000000.123:42): a0="ls"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, bare := parseFields(tt.line)
+			argv, ok := execveArgv(fields, decodeFields("EXECVE", fields, bare))
+			if ok != (tt.want != nil) || !slices.Equal(argv, tt.want) {
+				t.Errorf("execveArgv = %q, %v; want %q", argv, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeExecve(t *testing.T) {
+	var children []Event
+	for _, line := range []string{
+		`type=SYSCALL msg=audit(1700000000.123:42): arch=c000003e syscall=59 success=yes`,
+		`type=EXECVE msg=audit(1700000000.123:42): argc=3 a0="grep" a1_len=6 a1[0]="foo"`,
+		`type=EXECVE msg=audit(1700000000.123:42): a1[1]="bar" a2="/etc/hosts"`,
+	} {
+		ev, _ := parseLine(line)
+		children = append(children, ev)
+	}
+	argv, ok := mergeExecve(children)
+	if want := []string{"grep", "foobar", "/etc/hosts"}; !ok || !slices.Equal(argv, want) {
+		t.Errorf("mergeExecve = %q, %v; want %q", argv, ok, want)
+	}
+	if _, ok := mergeExecve(children[:1]); ok {
+		t.Error("mergeExecve without EXECVE records reported an argv")
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"ls", "-l"}, `ls -l`},
+		{[]string{"cat", "/tmp/a b"}, `cat '/tmp/a b'`},
+		{[]string{"touch", ""}, `touch ''`},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"sh", "-c", "echo $HOME"}, `sh -c 'echo $HOME'`},
+	}
+	for _, tt := range tests {
+		if got := commandLine(tt.argv); got != tt.want {
+			t.Errorf("commandLine(%q) = %s, want %s", tt.argv, got, tt.want)
+		}
+	}
+}
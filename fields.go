@@ -0,0 +1,70 @@
+package main
+
+import "strings"
+
+// parseFields tokenizes the key=value pairs of an audit record. Values may be
+// double-quoted, bare (which includes hex-encoded values), or single-quoted,
+// as in the msg='op=login acct="root" ...' payload of USER_* records, whose
+// pairs are merged in without overriding the outer ones. The type and the
+// msg=audit(ts:serial) header are left out; they are on the Event already.
+// bare holds the keys whose values were not quoted.
+func parseFields(s string) (fields map[string]string, bare map[string]bool) {
+	fields = make(map[string]string)
+	bare = make(map[string]bool)
+	for {
+		s = strings.TrimLeft(s, " ")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := s[:eq]
+		if sp := strings.IndexByte(key, ' '); sp >= 0 {
+			// a bare word such as "avc:" or "{"
+			s = s[sp+1:]
+			continue
+		}
+		s = s[eq+1:]
+
+		var val string
+		if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+			quote := s[0]
+			end := strings.IndexByte(s[1:], quote)
+			if end < 0 {
+				val, s = s[1:], ""
+			} else {
+				val, s = s[1:end+1], s[end+2:]
+			}
+			if quote == '\'' {
+				inner, innerBare := parseFields(val)
+				for k, v := range inner {
+					if _, ok := fields[k]; !ok {
+						fields[k] = v
+						if innerBare[k] {
+							bare[k] = true
+						}
+					}
+				}
+				continue
+			}
+		} else if len(s) > 0 && s[0] == '{' {
+			// ENRICHED SADDR={ saddr_fam=inet laddr=8.8.8.8 lport=53 }
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				end = len(s) - 1
+			}
+			val, s = strings.TrimSpace(s[1:end]), s[end+1:]
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			val, s = s[:end], s[end:]
+			if key == "type" || strings.HasPrefix(val, "audit(") {
+				continue
+			}
+			bare[key] = true
+		}
+		fields[key] = val
+	}
+	return fields, bare
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		fields map[string]string
+		bare   []string
+	}{
+		{
+			name: "syscall",
+			line: `type=SYSCALL msg=audit(1700000000.123:42): arch=c000003e syscall=59 success=yes exit=0 ppid=812 pid=901 auid=1000 comm="ls" exe="/usr/bin/ls" key="collector"`,
+			fields: map[string]string{
+				"arch": "c000003e", "syscall": "59", "success": "yes", "exit": "0", "ppid": "812",
+				"pid": "901", "auid": "1000", "comm": "ls", "exe": "/usr/bin/ls", "key": "collector",
+			},
+			bare: []string{"arch", "syscall", "success", "exit", "ppid", "pid", "auid"},
+		},
+		{
+			name: "user message",
+			line: `type=USER_LOGIN msg=audit(1700000000.456:43): pid=1234 uid=0 auid=1000 ses=3 msg='op=login acct="root" exe="/usr/sbin/sshd" hostname=? addr=10.0.0.1 terminal=ssh res=success'`,
+			fields: map[string]string{
+				"pid": "1234", "uid": "0", "auid": "1000", "ses": "3", "op": "login", "acct": "root",
+				"exe": "/usr/sbin/sshd", "hostname": "?", "addr": "10.0.0.1", "terminal": "ssh", "res": "success",
+			},
+			bare: []string{"pid", "uid", "auid", "ses", "op", "hostname", "addr", "terminal", "res"},
+		},
+		{
+			name:   "inner pairs do not override outer ones",
+			line:   `type=USER_CMD msg=audit(1700000000.456:44): pid=1 msg='pid=2 cmd=6C73'`,
+			fields: map[string]string{"pid": "1", "cmd": "6C73"},
+			bare:   []string{"pid", "cmd"},
+		},
+		{
+			name:   "enriched braces",
+			line:   `SADDR={ saddr_fam=inet laddr=8.8.8.8 lport=53 } SYSCALL=connect`,
+			fields: map[string]string{"SADDR": "saddr_fam=inet laddr=8.8.8.8 lport=53", "SYSCALL": "connect"},
+			bare:   []string{"SYSCALL"},
+		},
+		{
+			name:   "avc bare words",
+			line:   `type=AVC msg=audit(1700000000.789:45): avc:  denied  { read } for  pid=5 comm="cat" name="shadow" dev="dm-0" ino=1234`,
+			fields: map[string]string{"pid": "5", "comm": "cat", "name": "shadow", "dev": "dm-0", "ino": "1234"},
+			bare:   []string{"pid", "ino"},
+		},
+		{
+			name:   "unterminated quote",
+			line:   `type=SYSCALL msg=audit(1700000000.123:46): pid=7 comm="abc`,
+			fields: map[string]string{"pid": "7", "comm": "abc"},
+			bare:   []string{"pid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, bare := parseFields(tt.line)
+			if !maps.Equal(fields, tt.fields) {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+			want := make(map[string]bool)
+			for _, k := range tt.bare {
+				want[k] = true
+			}
+			if !maps.Equal(bare, want) {
+				t.Errorf("bare = %v, want %v", bare, want)
+			}
+		})
+	}
+}
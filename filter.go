@@ -0,0 +1,69 @@
+package main
+
+import "strings"
+
+// Filter keeps the events produced by the collector's own rules, identified
+// by their audit key, plus any record types asked for explicitly. Events are
+// judged after assembly, so the PATH, CWD and other records of a keyed
+// syscall are kept along with its SYSCALL record.
+type Filter struct {
+	keys  map[string]bool
+	types map[string]bool
+}
+
+// NewFilter returns nil, which keeps everything, when both lists are empty.
+func NewFilter(keys, types []string) *Filter {
+	if len(keys) == 0 && len(types) == 0 {
+		return nil
+	}
+	f := &Filter{keys: make(map[string]bool), types: make(map[string]bool)}
+	for _, k := range keys {
+		f.keys[k] = true
+	}
+	for _, t := range types {
+		f.types[strings.ToUpper(t)] = true
+	}
+	return f
+}
+
+func (f *Filter) Keep(ev Event) bool {
+	if f == nil {
+		return true
+	}
+	for _, r := range append([]Event{ev}, ev.Records...) {
+		if f.types[r.Type] {
+			return true
+		}
+		for _, k := range recordKeys(r) {
+			if f.keys[k] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordKeys returns the keys of the rules that matched a record. A rule
+// with several keys logs them hex-encoded and separated by \x01, which
+// decodeFields turns into a comma-separated list.
+func recordKeys(ev Event) []string {
+	v, ok := ev.Decoded["key"]
+	if !ok {
+		v = ev.Fields["key"]
+	}
+	if v == "" || v == "(null)" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditArches names the AUDIT_ARCH_* values found in the arch field.
+var auditArches = map[string]string{
+	"c000003e": "x86_64",
+	"40000003": "i386",
+	"c00000b7": "aarch64",
+	"40000028": "arm",
+	"c00000f3": "riscv64",
+	"80000015": "ppc64",
+	"c0000015": "ppc64le",
+	"80000016": "s390x",
+}
+
+var (
+	uidFields = map[string]bool{
+		"uid": true, "auid": true, "euid": true, "suid": true, "fsuid": true,
+		"ouid": true, "obj_uid": true, "sauid": true, "inode_uid": true,
+	}
+	gidFields = map[string]bool{
+		"gid": true, "egid": true, "sgid": true, "fsgid": true,
+		"ogid": true, "obj_gid": true, "inode_gid": true,
+	}
+)
+
+var (
+	users  = &idNames{path: "/etc/passwd"}
+	groups = &idNames{path: "/etc/group"}
+)
+
+// interpret translates the numeric fields of a record into the names
+// `ausearch -i` would show: the architecture, the syscall name for that
+// architecture, a negative exit as an errno name, and uids and gids as user
+// and group names. It returns nil when there is nothing to interpret.
+func interpret(fields map[string]string) map[string]string {
+	out := make(map[string]string)
+	arch := auditArches[strings.ToLower(fields["arch"])]
+	if arch != "" {
+		out["arch"] = arch
+	}
+	if n, err := strconv.Atoi(fields["syscall"]); err == nil {
+		if name, ok := syscallTables[arch][n]; ok {
+			out["syscall"] = name
+		}
+	}
+	if n, err := strconv.Atoi(fields["exit"]); err == nil && n < 0 {
+		if name, ok := errnoNames[-n]; ok {
+			out["exit"] = name
+		}
+	}
+	for k, v := range fields {
+		var db *idNames
+		switch {
+		case uidFields[k]:
+			db = users
+		case gidFields[k]:
+			db = groups
+		default:
+			continue
+		}
+		if name, ok := db.lookup(v); ok {
+			out[k] = name
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// idNames resolves ids to names from a passwd or group style file, rereading
+// it when it changes.
+type idNames struct {
+	mu      sync.Mutex
+	path    string
+	names   map[string]string
+	mtime   time.Time
+	checked time.Time
+}
+
+func (d *idNames) lookup(id string) (string, bool) {
+	if id == "4294967295" || id == "-1" {
+		// (uid_t)-1: not set, as for auid before login
+		return "unset", true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.checked) > 10*time.Second {
+		d.checked = time.Now()
+		d.reload()
+	}
+	name, ok := d.names[id]
+	return name, ok
+}
+
+func (d *idNames) reload() {
+	fi, err := os.Stat(d.path)
+	if err != nil || fi.ModTime().Equal(d.mtime) {
+		return
+	}
+	f, err := os.Open(d.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	names := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// name:password:id:...
+		parts := strings.Split(sc.Text(), ":")
+		if len(parts) < 3 || strings.HasPrefix(parts[0], "#") {
+			continue
+		}
+		if _, ok := names[parts[2]]; !ok {
+			names[parts[2]] = parts[0]
+		}
+	}
+	d.names = names
+	d.mtime = fi.ModTime()
+}
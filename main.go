@@ -5,17 +5,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"slices"
@@ -24,26 +30,58 @@ import (
 )
 
 type Event struct {
-	Type      string `json:"type"`
-	Timestamp string `json:"timestamp"`
-	Message   string `json:"message"`
+	Type        string            `json:"type"`
+	Node        string            `json:"node,omitempty"`
+	Timestamp   string            `json:"timestamp"`
+	TimeSource  string            `json:"time_source"` // "record", or "synthesized" when the record had no timestamp
+	Serial      uint64            `json:"serial,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`      // the record's key=value pairs
+	Decoded     map[string]string `json:"decoded,omitempty"`     // readable values of hex-encoded fields
+	Interpreted map[string]string `json:"interpreted,omitempty"` // names for numeric fields, as ausearch -i
+	Argv        []string          `json:"argv,omitempty"`        // EXECVE arguments
+	Cmdline     string            `json:"cmdline,omitempty"`     // Argv joined for display
+	Sockaddr    *Sockaddr         `json:"sockaddr,omitempty"`    // decoded SOCKADDR saddr
+	Historical  bool              `json:"historical,omitempty"`  // read by backfill rather than live tailing
+	Records     []Event           `json:"records,omitempty"`     // the records of a multi-record event
+
+	seqs []uint64 // checkpoint sequence numbers of the lines behind the event
 }
 
 type Batch struct {
-	Timestamp time.Time `json:"timestamp"`
-	Logs      []Event   `json:"logs"`
+	Timestamp      time.Time `json:"timestamp"`
+	Logs           []Event   `json:"logs"`
+	SelfSuppressed int64     `json:"self_suppressed"` // collector's own events dropped since startup
+	Dropped        int64     `json:"dropped"`         // records dropped on full queues or netlink overruns since startup
+}
+
+// seqs returns the checkpoint sequence numbers of every line in the batch.
+func (b Batch) seqs() []uint64 {
+	var seqs []uint64
+	for _, ev := range b.Logs {
+		seqs = append(seqs, ev.seqs...)
+	}
+	return seqs
 }
 
-var client = http.DefaultClient
+// client gives up on an endpoint that stops answering rather than hang the
+// collector, which also keeps shutdown bounded.
+var client = &http.Client{Timeout: 30 * time.Second}
 
 func postJSON(endpoint string, payload any) error {
 	reqID := uuid.New().String()
 	log.Printf("sending %d logs to %s (reqID: %s)", len(payload.(Batch).Logs), endpoint, reqID)
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return &permanentError{err}
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return &permanentError{fmt.Errorf("endpoint %s is not an http or https URL", endpoint)}
 	}
-	req, _ := http.NewRequest("POST", endpoint, bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
@@ -56,99 +94,581 @@ func postJSON(endpoint string, payload any) error {
 		io.Copy(io.Discard, resp.Body)
 		log.Printf("logs sent with status: %s (reqID: %s)", resp.Status, reqID)
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return fmt.Errorf("bad status %s", resp.Status)
+			he := &httpError{status: resp.Status, code: resp.StatusCode}
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+				he.retryAfter = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			}
+			return he
 		}
 	}
 	log.Printf("logs sent (reqID: %s)", reqID)
 	return nil
 }
 
-var msgRe = regexp.MustCompile(`msg=audit\\((\\d+\\.\\d+):\\d+\\)`)
+// msgRe matches the record id, msg=audit(seconds.fraction:serial).
+var msgRe = regexp.MustCompile(`msg=audit\((\d+)\.(\d+):(\d+)\)`)
 
 func parseLine(line string) (Event, bool) {
 	if line == "" {
 		return Event{}, false
 	}
-	ev := Event{Message: line}
-	for _, tok := range strings.Fields(line) {
+	// the ENRICHED log_format appends the interpreted fields after a 0x1D
+	raw, enriched, _ := strings.Cut(line, "\x1d")
+	ev := Event{Message: raw}
+	for _, tok := range strings.Fields(raw) {
 		if strings.HasPrefix(tok, "type=") {
 			ev.Type = strings.TrimPrefix(tok, "type=")
 			break
 		}
 	}
-	if m := msgRe.FindStringSubmatch(line); len(m) == 2 {
-		if f, err := strconv.ParseFloat(m[1], 64); err == nil {
-			sec := int64(f)
-			nsec := int64((f - float64(sec)) * 1e9)
-			ev.Timestamp = time.Unix(sec, nsec).UTC().Format(time.RFC3339Nano)
+	fields, bare := parseFields(raw)
+	if node, ok := fields["node"]; ok {
+		// name_format prefixes node=<name>, which matters in aggregated logs
+		ev.Node = node
+		delete(fields, "node")
+	}
+	ev.Fields = fields
+	ev.Decoded = decodeFields(ev.Type, fields, bare)
+	ev.Interpreted = interpret(fields)
+	if enriched != "" {
+		// the writing host's interpretation beats ours, whose passwd and
+		// group files may not be the ones that applied
+		extra, _ := parseFields(enriched)
+		if ev.Interpreted == nil && len(extra) > 0 {
+			ev.Interpreted = make(map[string]string, len(extra))
+		}
+		for k, v := range extra {
+			ev.Interpreted[strings.ToLower(k)] = v
 		}
 	}
-
-	// todo: figure out why some logs don't have a timestamp
-	if ev.Timestamp == "" {
+	if ev.Type == "EXECVE" {
+		if argv, ok := execveArgv(fields, ev.Decoded); ok {
+			ev.Argv, ev.Cmdline = argv, commandLine(argv)
+		}
+	}
+	if saddr, ok := fields["saddr"]; ok {
+		ev.Sockaddr, _ = decodeSockaddr(saddr)
+	}
+	if ts, serial, ok := parseAuditID(raw); ok {
+		ev.Timestamp = ts.Format(time.RFC3339Nano)
+		ev.Serial = serial
+		ev.TimeSource = "record"
+	} else {
 		ev.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+		ev.TimeSource = "synthesized"
 	}
 
 	return ev, true
 }
 
+// parseAuditID extracts the timestamp and serial number from a record's
+// msg=audit(1700000000.123:456). The fraction is converted to nanoseconds
+// with integer arithmetic so no precision is lost.
+func parseAuditID(line string) (time.Time, uint64, bool) {
+	m := msgRe.FindStringSubmatch(line)
+	if m == nil {
+		return time.Time{}, 0, false
+	}
+	sec, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	frac := m[2]
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	nsec, _ := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	serial, err := strconv.ParseUint(m[3], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return time.Unix(sec, nsec).UTC(), serial, true
+}
+
 func main() {
+	os.Exit(run())
+}
+
+// run is main with the exit status returned rather than passed to os.Exit,
+// so deferred cleanup such as removing the audit rules still happens.
+func run() int {
 	flushSize := flag.Int("flush", 2048, "number of events before sending batch")
+	flushInterval := flag.Duration("flush-interval", 5*time.Second, "longest an event waits in a partial batch (0 disables)")
 	key := flag.String("key", "collector", "audit key")
 	endpoint := flag.String("endpoint", "http://127.0.0.1:3000/api/v1.0/logs", "POST target")
+	auditLog := flag.String("audit-log", "/var/log/audit/audit.log", "audit log file to follow")
+	checkpointPath := flag.String("checkpoint", "/var/lib/collector/checkpoint.json", "file recording the last delivered position (empty disables)")
+	doBackfill := flag.Bool("backfill", false, "ship rotated and existing audit logs as historical events before tailing")
+	groupTimeout := flag.Duration("group-timeout", 2*time.Second, "how long to wait for the rest of a multi-record event (0 disables grouping)")
+	filterKeys := flag.Bool("filter", true, "only ship events from rules tagged with -key or -keep-keys")
+	keepKeys := flag.String("keep-keys", "", "comma-separated extra audit keys to ship")
+	keepTypes := flag.String("keep-types", "", "comma-separated record types to ship regardless of key, e.g. USER_LOGIN")
+	rulesPath := flag.String("rules", "", "audit.rules file to install instead of the built-in execve/openat/connect profile")
+	compat := flag.Bool("compat", true, "also install arch=b32 copies of arch=b64 rules to catch 32-bit processes")
+	onRuleError := flag.String("on-rule-error", "abort", "when audit rules fail to install: abort, or degraded to keep collecting")
+	reconcileInterval := flag.Duration("reconcile-interval", time.Minute, "how often to check for and repair removed audit rules (0 disables)")
+	source := flag.String("source", "file", "where audit records come from: file (audit.log), netlink (kernel, no auditd; rules are still loaded with auditctl) or stdin (auditd plugin)")
+	retries := flag.Int("retries", 5, "attempts to send a batch before giving up on it")
+	retryBase := flag.Duration("retry-base", 500*time.Millisecond, "wait before the first retry, doubled for each one after")
+	retryMax := flag.Duration("retry-max", 30*time.Second, "longest wait between retries")
+	spoolDir := flag.String("spool", "/var/lib/collector/spool", "directory holding batches the endpoint would not take, for later delivery (empty disables)")
+	spoolMax := flag.Int64("spool-max", 256<<20, "bytes the spool may use before its oldest batches are discarded")
+	spoolSegment := flag.Int64("spool-segment", 16<<20, "size at which a spool segment file is closed and a new one started")
+	senders := flag.Int("senders", 1, "batches delivered at once; more than one lets batches arrive out of order")
+	queueSize := flag.Int("queue", 16384, "lines read ahead of the parser")
+	sendQueue := flag.Int("send-queue", 8, "batches waiting for a sender")
+	onFull := flag.String("on-full", queueBlock, "when a queue is full: block, which stops reading and lets the source back up, or drop")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for the final batch to send on SIGTERM/SIGINT")
+	config := flag.String("config", "", "file of further flags, one -name=value per line; flags on the command line win")
 	flag.Parse()
+	if *config != "" {
+		err := loadConfig(flag.CommandLine, *config)
+		// plugin-conf is what writes the file in the first place
+		if err != nil && !(flag.Arg(0) == "plugin-conf" && errors.Is(err, fs.ErrNotExist)) {
+			log.Fatalf("config: %v", err)
+		}
+	}
+
+	if flag.Arg(0) == "plugin-conf" {
+		if err := writePluginConf(flag.CommandLine, flag.Arg(1)); err != nil {
+			log.Fatalf("plugin-conf: %v", err)
+		}
+		return 0
+	}
 
 	if os.Geteuid() != 0 {
 		log.Fatal("must run as root")
 	}
 
-	// install audit rules once
-	rules := [][]string{
-		{"-a", "exit,always", "-F", "arch=b64", "-S", "execve", "-k", *key},
-		{"-a", "exit,always", "-F", "arch=b64", "-S", "openat", "-k", *key},
-		{"-a", "exit,always", "-F", "arch=b64", "-S", "connect", "-k", *key},
+	switch *source {
+	case "file":
+	case "netlink", "stdin":
+		// records are not replayable, so there is nothing to checkpoint
+		*checkpointPath = ""
+	default:
+		log.Fatalf("unknown source %q", *source)
+	}
+	ckpt := newCheckpointer(*checkpointPath)
+	spool, err := OpenSpool(*spoolDir, min(*spoolSegment, *spoolMax), *spoolMax)
+	if err != nil {
+		log.Fatalf("spool: %v", err)
+	}
+	if spool != nil {
+		defer spool.Close()
+	}
+
+	rules := defaultRules(*key)
+	if *rulesPath != "" {
+		var err error
+		if rules, err = loadRules(*rulesPath, *key); err != nil {
+			log.Fatalf("rules: %v", err)
+		}
+	}
+	var optional []Rule
+	if *compat {
+		optional = compatRules(rules, runtime.GOARCH)
+	}
+	if *onRuleError != "abort" && *onRuleError != "degraded" {
+		log.Fatalf("unknown -on-rule-error %q", *onRuleError)
+	}
+	if *onFull != queueBlock && *onFull != queueDrop {
+		log.Fatalf("unknown -on-full %q", *onFull)
+	}
+	if u, err := url.Parse(*endpoint); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		// batches sent there would fail for good, or sit in the spool
+		log.Fatalf("-endpoint %q is not an http or https URL", *endpoint)
+	}
+	if *filterKeys && *groupTimeout <= 0 {
+		// only the SYSCALL record carries the key; its PATH, CWD, EXECVE and
+		// other records are kept by being grouped with it
+		log.Fatal("-filter needs grouping; set -group-timeout above 0 or use -filter=false")
+	}
+
+	self := newSelfFilter()
+	retry := retryPolicy{attempts: max(*retries, 1), base: *retryBase, max: *retryMax}
+	// at shutdown, with a spool there is no point waiting out retries
+	final := retry
+	if spool != nil {
+		final.attempts = 1
 	}
-	exec.Command("auditctl", "-D").Run()
-	for _, r := range rules {
-		exec.Command("auditctl", r...).Run()
+	// sendCtx cuts retries short once the shutdown timeout has passed
+	sendCtx, giveUp := context.WithCancel(context.Background())
+	defer giveUp()
+	post := func(ctx context.Context, batch Batch, p retryPolicy) error {
+		return p.do(ctx, func() error { return postJSON(*endpoint, batch) })
 	}
-	log.Println("audit rules installed; tailing audit.log…")
+	// send delivers a batch or, failing that, spools it. Once anything is
+	// spooled, later batches queue behind it so they still arrive in order.
+	send := func(batch Batch, p retryPolicy) error {
+		if spool == nil {
+			return post(sendCtx, batch, p)
+		}
+		if spool.Pending() {
+			return spool.Put(batch)
+		}
+		err := post(sendCtx, batch, p)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if serr := spool.Put(batch); serr != nil {
+			log.Printf("spool: %v", serr)
+			return err
+		}
+		log.Printf("send failed, spooled %d events: %v", len(batch.Logs), err)
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// a second signal kills the process outright
+		stop()
+	}()
+	go ckpt.Run(ctx)
+	defer ckpt.sync()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	tail := exec.CommandContext(ctx, "tail", "-F", "/var/log/audit/audit.log")
-	pipe, _ := tail.StdoutPipe()
-	if err := tail.Start(); err != nil {
-		log.Fatalf("tail start: %v", err)
+	// dropped records count as delivered for the checkpoint, which could
+	// otherwise never move past them
+	var dropped atomic.Int64
+	drop := func(seqs ...uint64) {
+		dropped.Add(int64(len(seqs)))
+		ckpt.ack(seqs...)
+	}
+
+	var failed atomic.Int64
+	batches := make(chan Batch, max(*sendQueue, 1))
+	var sending sync.WaitGroup
+	for range max(*senders, 1) {
+		sending.Add(1)
+		go func() {
+			defer sending.Done()
+			for batch := range batches {
+				p := retry
+				if ctx.Err() != nil {
+					p = final
+				}
+				if err := send(batch, p); err != nil {
+					log.Printf("send failed, dropping %d events: %v", len(batch.Logs), err)
+					failed.Add(1)
+					if retryable(err) {
+						// held back so a restart replays them
+						continue
+					}
+					// rejected outright; sending them again would not help
+				}
+				ckpt.ack(batch.seqs()...)
+			}
+		}()
 	}
 
 	var mu sync.Mutex
 	buf := make([]Event, 0, *flushSize)
+	closed := false
 
+	// flush hands the buffer to the senders; the caller holds mu
 	flush := func() {
-		if len(buf) == 0 {
+		if len(buf) == 0 || closed {
 			return
 		}
 		batch := Batch{
-			Timestamp: time.Now().UTC(),
-			Logs:      slices.Clone(buf),
+			Timestamp:      time.Now().UTC(),
+			Logs:           slices.Clone(buf),
+			SelfSuppressed: self.suppressed.Load(),
+			Dropped:        dropped.Load(),
 		}
 		buf = buf[:0] // clear the buffer
-		postJSON(*endpoint, batch)
+		if !put(batches, batch, *onFull) {
+			log.Printf("send queue full, dropping %d events", len(batch.Logs))
+			drop(batch.seqs()...)
+		}
+	}
+	add := func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		buf = append(buf, ev)
+		if len(buf) >= *flushSize {
+			flush()
+		}
+	}
+	// finish sends the rest of the buffer and waits for the senders to
+	// deliver everything queued
+	finish := func() {
+		mu.Lock()
+		flush()
+		closed = true
+		close(batches)
+		mu.Unlock()
+		sending.Wait()
 	}
+	var filter *Filter
+	if *filterKeys {
+		keys := append([]string{*key}, splitList(*keepKeys)...)
+		for _, r := range rules {
+			keys = append(keys, r.keys()...)
+		}
+		filter = NewFilter(keys, splitList(*keepTypes))
+	}
+	ship := func(ev Event) {
+		if self.drop(ev) || !filter.Keep(ev) {
+			// filtered lines count as delivered for the checkpoint
+			ckpt.ack(ev.seqs...)
+			return
+		}
+		add(ev)
+	}
+	asm := NewAssembler(*groupTimeout, ship)
+	go asm.Run(ctx)
 
-	sc := bufio.NewScanner(pipe)
-	for sc.Scan() {
-		if ev, ok := parseLine(sc.Text()); ok {
-			mu.Lock()
-			buf = append(buf, ev)
-			if len(buf) >= *flushSize {
-				flush()
+	if *flushInterval > 0 {
+		go func() {
+			t := time.NewTicker(*flushInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					mu.Lock()
+					flush()
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	if spool != nil {
+		go spool.Drain(ctx, func(b Batch) error { return post(ctx, b, retry) }, *retryMax)
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "backfill":
+		if _, err := backfill(*auditLog, func(ev Event, _ *Checkpoint) { asm.Add(ev) }); err != nil {
+			log.Fatalf("backfill: %v", err)
+		}
+		asm.Flush()
+		finish()
+		if failed.Load() > 0 {
+			return 1
+		}
+		return 0
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
+
+	// install audit rules once, keeping the collector's own syscalls out of the log
+	removeStale(*key)
+	ruleSet := NewRuleSet(append(self.rules(*key), rules...), optional)
+	status := "ok"
+	err = ruleSet.Install()
+	if err != nil {
+		log.Printf("audit rules failed to install: %v", err)
+		if *onRuleError == "abort" {
+			ruleSet.Remove()
+			log.Fatal("aborting; run with -on-rule-error=degraded to collect anyway")
+		}
+		status = "degraded"
+	}
+	defer ruleSet.Remove()
+	// the collector's own reports skip the batch so they arrive right away
+	report := func(ev Event) {
+		if err := send(Batch{Timestamp: time.Now().UTC(), Logs: []Event{ev}}, retry); err != nil {
+			log.Printf("%s event: %v", ev.Type, err)
+		}
+	}
+	report(statusEvent(status, len(ruleSet.added), err))
+	log.Printf("audit rules installed (%s); reading from %s…", status, *source)
+
+	if *reconcileInterval > 0 {
+		go func() {
+			t := time.NewTicker(*reconcileInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+				drift, err := ruleSet.Reconcile()
+				if err != nil {
+					log.Printf("rules: reconcile: %v", err)
+					continue
+				}
+				if drift != nil {
+					log.Printf("rules: drift: %d of ours missing, %d others added, %d others removed", len(drift.Missing), len(drift.Added), len(drift.Removed))
+					report(driftEvent(drift))
+				}
 			}
+		}()
+	}
+
+	var in io.Reader
+	var tail *Tailer
+	switch *source {
+	case "file":
+		tail = NewTailer(ctx, *auditLog)
+		defer tail.Close()
+		in = tail
+		var cp *Checkpoint
+		if ckpt != nil {
+			var err error
+			if cp, err = loadCheckpoint(ckpt.path); err != nil {
+				// starting at the end would silently skip everything since
+				// the last save
+				if !*doBackfill {
+					log.Printf("checkpoint load: %v; remove %s or run with -backfill", err, ckpt.path)
+					return 1
+				}
+				log.Printf("checkpoint load: %v; backfilling instead", err)
+			} else if cp != nil {
+				log.Printf("resuming from checkpoint (inode %d, offset %d, serial %d)", cp.Inode, cp.Offset, cp.Serial)
+			}
+		}
+		switch {
+		case *doBackfill && cp != nil:
+			// the history up to the checkpoint went out on an earlier run
+			log.Printf("checkpoint found; skipping backfill")
+		case *doBackfill:
+			// backfilled lines move the checkpoint like tailed ones, so a
+			// restart resumes rather than sending the history again
+			end, err := backfill(*auditLog, func(ev Event, pos *Checkpoint) {
+				if pos != nil {
+					ev.seqs = []uint64{ckpt.track(*pos)}
+				}
+				asm.Add(ev)
+			})
+			if err != nil {
+				log.Printf("backfill: %v", err)
+			} else {
+				// carry on tailing from where backfill stopped
+				cp = &end
+			}
+			asm.Flush()
+			mu.Lock()
+			flush()
 			mu.Unlock()
 		}
+		if cp != nil {
+			if err := tail.Resume(*cp); err != nil {
+				log.Printf("resume: %v", err)
+				return 1
+			}
+		}
+	case "netlink":
+		sock, err := dialAudit()
+		if err != nil {
+			log.Printf("netlink: %v", err)
+			return 1
+		}
+		ns, err := NewNetlinkSource(ctx, sock, func() { dropped.Add(1) })
+		if err != nil {
+			log.Printf("netlink: %v", err)
+			return 1
+		}
+		defer ns.Close()
+		in = ns
+	case "stdin":
+		// auditd dispatches records to plugins in the same format as audit.log.
+		// A non-blocking descriptor goes through the runtime poller, so
+		// closing it on shutdown interrupts a pending read.
+		if err := syscall.SetNonblock(syscall.Stdin, true); err != nil {
+			log.Printf("stdin: %v", err)
+		}
+		stdin := os.NewFile(uintptr(syscall.Stdin), "/dev/stdin")
+		go func() {
+			<-ctx.Done()
+			stdin.Close()
+		}()
+		in = stdin
+	}
+
+	// count the bytes behind each line so it can be mapped back to a file offset
+	var consumed int64
+	var serial uint64
+	sc := bufio.NewScanner(in)
+	sc.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && ctx.Err() != nil && bytes.IndexByte(data, '\n') < 0 {
+			// leave a line still being written for the next run
+			return 0, nil, nil
+		}
+		adv, tok, err := bufio.ScanLines(data, atEOF)
+		consumed += int64(adv)
+		return adv, tok, err
+	})
+	lines := make(chan queuedLine, max(*queueSize, 1))
+	parsed := make(chan struct{})
+	go func() {
+		defer close(parsed)
+		for l := range lines {
+			ev, ok := parseLine(l.text)
+			if !ok {
+				ckpt.ack(l.seq)
+				continue
+			}
+			ev.seqs = []uint64{l.seq}
+			asm.Add(ev)
+		}
+	}()
+	for sc.Scan() {
+		text := sc.Text()
+		if _, s, ok := parseAuditID(text); ok {
+			serial = s
+		}
+		var pos Checkpoint
+		if tail != nil {
+			pos = tail.Position(consumed)
+		}
+		pos.Serial = serial
+		seq := ckpt.track(pos)
+		if !put(lines, queuedLine{text: text, seq: seq}, *onFull) {
+			drop(seq)
+		}
 	}
-	if err := sc.Err(); err != nil {
+	close(lines)
+	code := 0
+	if err := sc.Err(); err != nil && ctx.Err() == nil {
 		log.Printf("scanner error: %v", err)
+		code = 1
+	}
+	if ctx.Err() != nil {
+		log.Printf("shutting down")
+	}
+
+	// ship whatever is still queued, grouped or buffered, bounded so an
+	// unreachable endpoint cannot hold up the stop; the deferred calls then
+	// remove the audit rules
+	failedBefore := failed.Load()
+	done := make(chan struct{})
+	go func() {
+		<-parsed
+		asm.Flush()
+		finish()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if failed.Load() > failedBefore {
+			code = 1
+		}
+	case <-time.After(*shutdownTimeout):
+		log.Printf("queued batches not sent within %s", *shutdownTimeout)
+		giveUp()
+		if spool != nil {
+			// keep what no sender has picked up yet for the next run
+			for more := true; more; {
+				select {
+				case batch, ok := <-batches:
+					more = ok && spool.Put(batch) == nil
+					if more {
+						ckpt.ack(batch.seqs()...)
+					}
+				default:
+					more = false
+				}
+			}
+		}
+		code = 1
 	}
+	return code
 }
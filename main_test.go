@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAuditID(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   time.Time
+		serial uint64
+		ok     bool
+	}{
+		{`type=SYSCALL msg=audit(1700000000.123:42): arch=c000003e`, time.Unix(1700000000, 123000000), 42, true},
+		{`type=EOE msg=audit(1700000000.5:7):`, time.Unix(1700000000, 500000000), 7, true},
+		{`node=web1 type=PATH msg=audit(1700000000.000000001:18446744073709551615): item=0`, time.Unix(1700000000, 1), 18446744073709551615, true},
+		{`type=SYSCALL msg=audit(1700000000.1234567891:3):`, time.Unix(1700000000, 123456789), 3, true},
+		{`type=SYSCALL msg=audit(1700000000.123:18446744073709551616):`, time.Time{}, 0, false},
+		{`type=DAEMON_START msg=audit(1700000000:1):`, time.Time{}, 0, false},
+		{`hello world`, time.Time{}, 0, false},
+	}
+	for _, tt := range tests {
+		ts, serial, ok := parseAuditID(tt.line)
+		if ok != tt.ok || !ts.Equal(tt.want) || serial != tt.serial {
+			t.Errorf("parseAuditID(%q) = %v, %d, %v; want %v, %d, %v", tt.line, ts, serial, ok, tt.want, tt.serial, tt.ok)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	line := "node=web1 type=EXECVE msg=audit(1700000000.123:42): argc=2 a0=\"ls\" a1=2F746D702F612062\x1dAUID=\"alice\""
+	ev, ok := parseLine(line)
+	if !ok {
+		t.Fatal("parseLine rejected the line")
+	}
+	if ev.Type != "EXECVE" || ev.Node != "web1" || ev.Serial != 42 || ev.TimeSource != "record" {
+		t.Errorf("header = %q %q %d %q", ev.Type, ev.Node, ev.Serial, ev.TimeSource)
+	}
+	if ev.Timestamp != "2023-11-14T22:13:20.123Z" {
+		t.Errorf("timestamp = %s", ev.Timestamp)
+	}
+	if _, ok := ev.Fields["node"]; ok {
+		t.Error("node left in Fields")
+	}
+	if ev.Cmdline != "ls '/tmp/a b'" {
+		t.Errorf("cmdline = %s", ev.Cmdline)
+	}
+	if ev.Interpreted["auid"] != "alice" {
+		t.Errorf("interpreted auid = %q", ev.Interpreted["auid"])
+	}
+	if _, ok := parseLine(""); ok {
+		t.Error("parseLine accepted an empty line")
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// netlinkSocket is the NETLINK_AUDIT socket. It sends and receives whole
+// netlink datagrams; Receive returns nil, nil when its read timeout expires
+// so callers can check for cancellation.
+type netlinkSocket interface {
+	Send(b []byte) error
+	Receive() ([]byte, error)
+	Close() error
+}
+
+const (
+	nlmsgHdrLen = 16
+	nlmsgError  = 2
+	nlmsgDone   = 3
+
+	nlmFRequest = 0x1
+	nlmFAck     = 0x4
+
+	auditSet          = 1001
+	auditStatusEnable = 0x1
+	auditStatusPID    = 0x4
+)
+
+// auditTypes names the record types the kernel delivers, as auditd writes
+// them to audit.log.
+var auditTypes = map[uint16]string{
+	1005: "USER", 1006: "LOGIN",
+	1100: "USER_AUTH", 1101: "USER_ACCT", 1102: "USER_MGMT", 1103: "CRED_ACQ",
+	1104: "CRED_DISP", 1105: "USER_START", 1106: "USER_END", 1107: "USER_AVC",
+	1108: "USER_CHAUTHTOK", 1109: "USER_ERR", 1110: "CRED_REFR", 1111: "USYS_CONFIG",
+	1112: "USER_LOGIN", 1113: "USER_LOGOUT", 1114: "ADD_USER", 1115: "DEL_USER",
+	1116: "ADD_GROUP", 1117: "DEL_GROUP", 1118: "DAC_CHECK", 1119: "CHGRP_ID",
+	1120: "TEST", 1121: "TRUSTED_APP", 1122: "USER_SELINUX_ERR", 1123: "USER_CMD",
+	1124: "USER_TTY", 1125: "CHUSER_ID", 1126: "GRP_AUTH", 1127: "SYSTEM_BOOT",
+	1128: "SYSTEM_SHUTDOWN", 1129: "SYSTEM_RUNLEVEL", 1130: "SERVICE_START",
+	1131: "SERVICE_STOP", 1132: "GRP_MGMT", 1133: "GRP_CHAUTHTOK", 1134: "MAC_CHECK",
+	1135: "ACCT_LOCK", 1136: "ACCT_UNLOCK", 1137: "USER_DEVICE", 1138: "SOFTWARE_UPDATE",
+	1300: "SYSCALL", 1302: "PATH", 1303: "IPC", 1304: "SOCKETCALL", 1305: "CONFIG_CHANGE",
+	1306: "SOCKADDR", 1307: "CWD", 1309: "EXECVE", 1311: "IPC_SET_PERM", 1312: "MQ_OPEN",
+	1313: "MQ_SENDRECV", 1314: "MQ_NOTIFY", 1315: "MQ_GETSETATTR", 1316: "KERNEL_OTHER",
+	1317: "FD_PAIR", 1318: "OBJ_PID", 1319: "TTY", 1320: "EOE", 1321: "BPRM_FCAPS",
+	1322: "CAPSET", 1323: "MMAP", 1324: "NETFILTER_PKT", 1325: "NETFILTER_CFG",
+	1326: "SECCOMP", 1327: "PROCTITLE", 1328: "FEATURE_CHANGE", 1329: "REPLACE",
+	1330: "KERN_MODULE", 1331: "FANOTIFY", 1332: "TIME_INJOFFSET", 1333: "TIME_ADJNTPVAL",
+	1334: "BPF", 1335: "EVENT_LISTENER", 1336: "URINGOP", 1337: "OPENAT2",
+	1400: "AVC", 1401: "SELINUX_ERR", 1402: "AVC_PATH", 1403: "MAC_POLICY_LOAD",
+	1404: "MAC_STATUS", 1405: "MAC_CONFIG_CHANGE", 1406: "MAC_UNLBL_ALLOW",
+	1415: "MAC_IPSEC_EVENT", 1420: "MAC_UNLBL_STCADD", 1421: "MAC_UNLBL_STCDEL",
+	1700: "ANOM_PROMISCUOUS", 1701: "ANOM_ABEND", 1702: "ANOM_LINK", 1703: "ANOM_CREAT",
+	2000: "KERNEL",
+}
+
+func auditTypeName(t uint16) string {
+	if name, ok := auditTypes[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN[%d]", t)
+}
+
+// NetlinkSource registers the collector as the audit daemon and renders the
+// records the kernel sends it as audit.log lines, so they go through the same
+// scanner and parseLine path as the file source. It only receives records;
+// the audit rules are loaded with auditctl as for the other sources, so the
+// host needs the audit userspace tools even without auditd running.
+type NetlinkSource struct {
+	ctx     context.Context
+	sock    netlinkSocket
+	lost    func() // called when the kernel drops records we were too slow for
+	seq     uint32
+	pending []byte
+}
+
+func NewNetlinkSource(ctx context.Context, sock netlinkSocket, lost func()) (*NetlinkSource, error) {
+	s := &NetlinkSource{ctx: ctx, sock: sock, lost: lost}
+	if err := s.setPID(uint32(os.Getpid())); err != nil {
+		sock.Close()
+		return nil, fmt.Errorf("register audit daemon: %w", err)
+	}
+	return s, nil
+}
+
+func (s *NetlinkSource) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.ctx.Err() != nil {
+			return 0, io.EOF
+		}
+		b, err := s.receive()
+		if err != nil {
+			return 0, err
+		}
+		s.handle(b)
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// receive reads a datagram. ENOBUFS means the socket's receive buffer
+// overflowed and the kernel threw records away; the socket itself is fine,
+// so that is reported as a loss rather than an error.
+func (s *NetlinkSource) receive() ([]byte, error) {
+	b, err := s.sock.Receive()
+	if errors.Is(err, syscall.ENOBUFS) {
+		log.Printf("netlink: receive buffer overrun; audit records lost")
+		if s.lost != nil {
+			s.lost()
+		}
+		return nil, nil
+	}
+	return b, err
+}
+
+// Close gives up the audit daemon registration so auditd can take it back.
+func (s *NetlinkSource) Close() error {
+	if err := s.send(auditSet, auditStatus(auditStatusPID, 0, 0)); err != nil {
+		log.Printf("netlink: unregister: %v", err)
+	}
+	return s.sock.Close()
+}
+
+func (s *NetlinkSource) setPID(pid uint32) error {
+	if err := s.send(auditSet, auditStatus(auditStatusEnable|auditStatusPID, 1, pid)); err != nil {
+		return err
+	}
+	// wait for the ack, keeping any records that arrive first
+	for {
+		if s.ctx.Err() != nil {
+			return s.ctx.Err()
+		}
+		b, err := s.receive()
+		if err != nil {
+			return err
+		}
+		acked, err := s.ack(b)
+		if err != nil || acked {
+			return err
+		}
+		s.handle(b)
+	}
+}
+
+func (s *NetlinkSource) send(typ uint16, payload []byte) error {
+	s.seq++
+	b := make([]byte, nlmsgHdrLen+len(payload))
+	binary.NativeEndian.PutUint32(b[0:], uint32(len(b)))
+	binary.NativeEndian.PutUint16(b[4:], typ)
+	binary.NativeEndian.PutUint16(b[6:], nlmFRequest|nlmFAck)
+	binary.NativeEndian.PutUint32(b[8:], s.seq)
+	copy(b[nlmsgHdrLen:], payload)
+	return s.sock.Send(b)
+}
+
+// ack reports whether b holds the acknowledgement of the last request.
+func (s *NetlinkSource) ack(b []byte) (bool, error) {
+	for _, m := range splitNetlink(b) {
+		if m.typ != nlmsgError || m.seq != s.seq || len(m.data) < 4 {
+			continue
+		}
+		if errno := int32(binary.NativeEndian.Uint32(m.data)); errno != 0 {
+			return true, syscall.Errno(-errno)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// handle appends the audit records in datagram b to the pending lines.
+func (s *NetlinkSource) handle(b []byte) {
+	for _, m := range splitNetlink(b) {
+		switch {
+		case m.typ == nlmsgError || m.typ == nlmsgDone:
+		case m.typ < 1100 && m.typ != 1005 && m.typ != 1006:
+			// replies to control messages, not records
+		default:
+			body := strings.TrimRight(string(m.data), "\x00\n")
+			s.pending = append(s.pending, "type="+auditTypeName(m.typ)+" msg="+body+"\n"...)
+		}
+	}
+}
+
+type netlinkMessage struct {
+	typ  uint16
+	seq  uint32
+	data []byte
+}
+
+func splitNetlink(b []byte) []netlinkMessage {
+	var msgs []netlinkMessage
+	for len(b) >= nlmsgHdrLen {
+		n := int(binary.NativeEndian.Uint32(b))
+		if n < nlmsgHdrLen || n > len(b) {
+			// clamp a bogus length to the datagram rather than dropping it
+			n = len(b)
+		}
+		msgs = append(msgs, netlinkMessage{
+			typ:  binary.NativeEndian.Uint16(b[4:]),
+			seq:  binary.NativeEndian.Uint32(b[8:]),
+			data: b[nlmsgHdrLen:n],
+		})
+		b = b[min((n+3)&^3, len(b)):]
+	}
+	return msgs
+}
+
+// auditStatus builds the start of struct audit_status for an AUDIT_SET request.
+func auditStatus(mask, enabled, pid uint32) []byte {
+	b := make([]byte, 10*4)
+	binary.NativeEndian.PutUint32(b[0:], mask)
+	binary.NativeEndian.PutUint32(b[4:], enabled)
+	binary.NativeEndian.PutUint32(b[12:], pid)
+	return b
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+type auditSocket struct {
+	fd  int
+	buf []byte
+}
+
+// dialAudit opens a NETLINK_AUDIT socket to the kernel.
+func dialAudit() (netlinkSocket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_AUDIT)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	tv := syscall.NsecToTimeval((500 * time.Millisecond).Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &auditSocket{fd: fd, buf: make([]byte, 1<<16)}, nil
+}
+
+func (s *auditSocket) Send(b []byte) error {
+	return syscall.Sendto(s.fd, b, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+}
+
+func (s *auditSocket) Receive() ([]byte, error) {
+	n, _, err := syscall.Recvfrom(s.fd, s.buf, 0)
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), s.buf[:n]...), nil
+}
+
+func (s *auditSocket) Close() error {
+	return syscall.Close(s.fd)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func dialAudit() (netlinkSocket, error) {
+	return nil, errors.New("the netlink audit source is only available on linux")
+}
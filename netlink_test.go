@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fakeSocket replays canned datagrams and records what is sent to it. A
+// datagram listed in errs is returned as that error instead.
+type fakeSocket struct {
+	recv   [][]byte
+	errs   map[int]error
+	reads  int
+	sent   [][]byte
+	closed bool
+}
+
+func (f *fakeSocket) Send(b []byte) error {
+	f.sent = append(f.sent, b)
+	return nil
+}
+
+func (f *fakeSocket) Receive() ([]byte, error) {
+	if len(f.recv) == 0 {
+		// behave like an expired read timeout
+		return nil, nil
+	}
+	b := f.recv[0]
+	f.recv = f.recv[1:]
+	f.reads++
+	if err := f.errs[f.reads-1]; err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (f *fakeSocket) Close() error {
+	f.closed = true
+	return nil
+}
+
+// nlmsg builds one netlink message, padded to four bytes.
+func nlmsg(typ uint16, seq uint32, data []byte) []byte {
+	n := nlmsgHdrLen + len(data)
+	b := make([]byte, (n+3)&^3)
+	binary.NativeEndian.PutUint32(b[0:], uint32(n))
+	binary.NativeEndian.PutUint16(b[4:], typ)
+	binary.NativeEndian.PutUint32(b[8:], seq)
+	copy(b[nlmsgHdrLen:], data)
+	return b
+}
+
+// nlack builds the NLMSG_ERROR acknowledging request seq with errno.
+func nlack(seq uint32, errno syscall.Errno) []byte {
+	data := make([]byte, 4+nlmsgHdrLen)
+	binary.NativeEndian.PutUint32(data, uint32(-int32(errno)))
+	return nlmsg(nlmsgError, seq, data)
+}
+
+func TestNewNetlinkSource(t *testing.T) {
+	const login = "audit(1700000000.123:41): pid=1 uid=0 msg='op=login res=success'"
+	sock := &fakeSocket{recv: [][]byte{
+		nlmsg(1112, 0, []byte(login)),
+		nlack(7, 0), // not ours
+		nlack(1, 0),
+	}}
+	s, err := NewNetlinkSource(context.Background(), sock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sock.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sock.sent))
+	}
+	m := splitNetlink(sock.sent[0])
+	if len(m) != 1 || m[0].typ != auditSet || m[0].seq != 1 {
+		t.Fatalf("sent %+v, want one AUDIT_SET with seq 1", m)
+	}
+	if pid := binary.NativeEndian.Uint32(m[0].data[12:]); pid != uint32(os.Getpid()) {
+		t.Errorf("registered pid %d, want %d", pid, os.Getpid())
+	}
+	if len(sock.recv) != 0 {
+		t.Errorf("%d datagrams left unread", len(sock.recv))
+	}
+	// the record that arrived before the ack is kept
+	want := "type=USER_LOGIN msg=" + login + "\n"
+	if got := string(s.pending); got != want {
+		t.Errorf("pending = %q, want %q", got, want)
+	}
+}
+
+func TestNewNetlinkSourceErrno(t *testing.T) {
+	sock := &fakeSocket{recv: [][]byte{nlack(1, syscall.EEXIST)}}
+	_, err := NewNetlinkSource(context.Background(), sock, nil)
+	if !errors.Is(err, syscall.EEXIST) {
+		t.Fatalf("err = %v, want EEXIST", err)
+	}
+	if !sock.closed {
+		t.Error("socket left open after failed registration")
+	}
+}
+
+func TestNewNetlinkSourceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewNetlinkSource(ctx, &fakeSocket{}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestNetlinkHandle(t *testing.T) {
+	var b []byte
+	b = append(b, nlmsg(1000, 3, make([]byte, 40))...) // AUDIT_GET reply
+	b = append(b, nlmsg(1300, 0, []byte("audit(1700000000.123:42): arch=c000003e syscall=59\x00"))...)
+	b = append(b, nlack(2, 0)...)
+	b = append(b, nlmsg(1006, 0, []byte("audit(1700000000.200:43): pid=9 old-auid=4294967295 auid=1000\n"))...)
+	b = append(b, nlmsg(nlmsgDone, 0, nil)...)
+	b = append(b, nlmsg(9999, 0, []byte("audit(1700000000.300:44): x=1"))...)
+
+	var s NetlinkSource
+	s.handle(b)
+	want := []string{
+		"type=SYSCALL msg=audit(1700000000.123:42): arch=c000003e syscall=59",
+		"type=LOGIN msg=audit(1700000000.200:43): pid=9 old-auid=4294967295 auid=1000",
+		"type=UNKNOWN[9999] msg=audit(1700000000.300:44): x=1",
+	}
+	if got := strings.Split(strings.TrimSuffix(string(s.pending), "\n"), "\n"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("lines:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestNetlinkRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sock := &fakeSocket{recv: [][]byte{
+		nil, // read timeout
+		nlmsg(1320, 0, []byte("audit(1700000000.123:42):")),
+	}}
+	s := &NetlinkSource{ctx: ctx, sock: sock}
+	got, err := io.ReadAll(io.LimitReader(s, int64(len("type=EOE msg=audit(1700000000.123:42):\n"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "type=EOE msg=audit(1700000000.123:42):\n" {
+		t.Errorf("read %q", got)
+	}
+	cancel()
+	if n, err := s.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+		t.Errorf("after cancel: %d, %v; want 0, EOF", n, err)
+	}
+}
+
+func TestNetlinkReadOverrun(t *testing.T) {
+	sock := &fakeSocket{
+		recv: [][]byte{
+			nil, // the kernel overran the receive buffer
+			nlmsg(1300, 0, []byte("audit(1700000000.123:42): syscall=59")),
+			nil,
+			nil,
+		},
+		errs: map[int]error{0: syscall.ENOBUFS, 2: syscall.ENOBUFS, 3: syscall.EBADF},
+	}
+	lost := 0
+	s := &NetlinkSource{ctx: context.Background(), sock: sock, lost: func() { lost++ }}
+	b := make([]byte, 256)
+	n, err := s.Read(b)
+	if err != nil {
+		t.Fatalf("read after overrun: %v", err)
+	}
+	if got := string(b[:n]); got != "type=SYSCALL msg=audit(1700000000.123:42): syscall=59\n" {
+		t.Errorf("read %q", got)
+	}
+	if _, err := s.Read(b); !errors.Is(err, syscall.EBADF) {
+		t.Errorf("err = %v, want EBADF", err)
+	}
+	if lost != 2 {
+		t.Errorf("lost called %d times, want 2", lost)
+	}
+}
+
+func TestSplitNetlinkBadLength(t *testing.T) {
+	b := nlmsg(1300, 0, []byte("audit(1:1): a=b"))
+	binary.NativeEndian.PutUint32(b, 1<<20)
+	m := splitNetlink(b)
+	if len(m) != 1 || string(m[0].data) != "audit(1:1): a=b\x00" {
+		t.Errorf("got %+v", m)
+	}
+}
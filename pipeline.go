@@ -0,0 +1,33 @@
+package main
+
+// The collector runs as a pipeline: the reader scans lines off the source,
+// a parser turns them into events and groups them into batches, and a pool
+// of senders delivers the batches. The stages are joined by bounded queues
+// so a slow endpoint cannot stall reading, at worst filling the queues.
+
+// queuedLine is a line read from the source on its way to the parser.
+type queuedLine struct {
+	text string
+	seq  uint64 // checkpoint sequence number
+}
+
+// onFull policies for a full queue.
+const (
+	queueBlock = "block" // wait for room, holding back the stage before
+	queueDrop  = "drop"  // discard what does not fit
+)
+
+// put queues v on ch, reporting false if ch was full and the policy is to
+// drop.
+func put[T any](ch chan<- T, v T, policy string) bool {
+	if policy == queueDrop {
+		select {
+		case ch <- v:
+			return true
+		default:
+			return false
+		}
+	}
+	ch <- v
+	return true
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestPut(t *testing.T) {
+	ch := make(chan int, 1)
+	if !put(ch, 1, queueDrop) {
+		t.Fatal("dropped with room in the queue")
+	}
+	if put(ch, 2, queueDrop) {
+		t.Fatal("queued past capacity")
+	}
+	if v := <-ch; v != 1 {
+		t.Errorf("got %d, want the first value", v)
+	}
+
+	// block waits for the consumer instead of dropping
+	done := make(chan bool)
+	ch <- 3
+	go func() { done <- put(ch, 4, queueBlock) }()
+	if v := <-ch; v != 3 {
+		t.Errorf("got %d, want 3", v)
+	}
+	if !<-done {
+		t.Error("block reported a drop")
+	}
+	if v := <-ch; v != 4 {
+		t.Errorf("got %d, want 4", v)
+	}
+}
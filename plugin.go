@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pluginConf renders an auditd plugin configuration that starts exe in stdin
+// mode with the flags set on fs, for /etc/audit/plugins.d. auditd passes a
+// plugin at most two arguments, so with more than one flag besides -source
+// they go in the -config file instead, and settings is what to write there.
+func pluginConf(fs *flag.FlagSet, exe string) (conf, settings string, err error) {
+	var flags []string
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "source" || f.Name == "config" {
+			return
+		}
+		flags = append(flags, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	args := []string{"-source=stdin"}
+	config := fs.Lookup("config").Value.String()
+	switch {
+	case config != "" && !filepath.IsAbs(config):
+		// auditd starts plugins from /
+		return "", "", fmt.Errorf("-config %s is not an absolute path", config)
+	case config != "":
+		args = append(args, "-config="+config)
+		settings = "# collector flags; generated by `collector plugin-conf`\n" + strings.Join(flags, "\n") + "\n"
+	case len(flags) > 1:
+		return "", "", fmt.Errorf("auditd passes a plugin at most 2 arguments; set -config to a file to hold the %d flags", len(flags))
+	default:
+		args = append(args, flags...)
+	}
+	for _, a := range args {
+		if strings.ContainsAny(a, " \t") {
+			// auditd splits args on whitespace
+			return "", "", fmt.Errorf("argument %q has whitespace; set -config to a file to hold it", a)
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintln(&b, "# audit event collector; generated by `collector plugin-conf`")
+	fmt.Fprintln(&b, "active = yes")
+	fmt.Fprintln(&b, "direction = out")
+	fmt.Fprintf(&b, "path = %s\n", exe)
+	fmt.Fprintln(&b, "type = always")
+	fmt.Fprintf(&b, "args = %s\n", strings.Join(args, " "))
+	fmt.Fprintln(&b, "format = string")
+	return b.String(), settings, nil
+}
+
+// writePluginConf writes the plugin configuration to path, or to stdout when
+// path is empty, and the -config file if the flags need one.
+func writePluginConf(fs *flag.FlagSet, path string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	conf, settings, err := pluginConf(fs, exe)
+	if err != nil {
+		return err
+	}
+	if settings != "" {
+		if err := os.WriteFile(fs.Lookup("config").Value.String(), []byte(settings), 0o640); err != nil {
+			return err
+		}
+	}
+	if path == "" {
+		_, err = os.Stdout.WriteString(conf)
+		return err
+	}
+	return os.WriteFile(path, []byte(conf), 0o640)
+}
+
+// loadConfig sets flags from a file of -name=value lines, skipping blank
+// lines and # comments. Flags already set on the command line win.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimLeft(line, "-"), "=")
+		if !ok || !strings.HasPrefix(line, "-") {
+			return fmt.Errorf("%s:%d: want -name=value", path, n)
+		}
+		if name == "config" {
+			return fmt.Errorf("%s:%d: -config cannot be nested", path, n)
+		}
+		if set[name] {
+			continue
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown flag -%s", path, n, name)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: -%s: %w", path, n, name, err)
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func pluginFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	fs := flag.NewFlagSet("collector", flag.ContinueOnError)
+	fs.String("source", "file", "")
+	fs.String("config", "", "")
+	fs.String("endpoint", "", "")
+	fs.String("key", "collector", "")
+	fs.Int("flush", 2048, "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestPluginConf(t *testing.T) {
+	conf, settings, err := pluginConf(pluginFlags(t, "-source=file", "-endpoint=http://siem:3000/logs"), "/usr/sbin/collector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# audit event collector; generated by ` + "`collector plugin-conf`" + `
+active = yes
+direction = out
+path = /usr/sbin/collector
+type = always
+args = -source=stdin -endpoint=http://siem:3000/logs
+format = string
+`
+	if conf != want {
+		t.Errorf("conf:\n%s\nwant:\n%s", conf, want)
+	}
+	if settings != "" {
+		t.Errorf("settings = %q, want none", settings)
+	}
+}
+
+func TestPluginConfTooManyArgs(t *testing.T) {
+	fs := pluginFlags(t, "-endpoint=http://siem:3000/logs", "-key=audit")
+	if _, _, err := pluginConf(fs, "/usr/sbin/collector"); err == nil || !strings.Contains(err.Error(), "-config") {
+		t.Errorf("err = %v, want one pointing at -config", err)
+	}
+	fs = pluginFlags(t, "-key=has space")
+	if _, _, err := pluginConf(fs, "/usr/sbin/collector"); err == nil {
+		t.Error("argument with whitespace accepted")
+	}
+}
+
+func TestPluginConfConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collector.flags")
+	fs := pluginFlags(t, "-config="+path, "-endpoint=http://siem:3000/logs", "-key=audit", "-flush=100")
+	conf, settings, err := pluginConf(fs, "/usr/sbin/collector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(conf, "\nargs = -source=stdin -config="+path+"\n") {
+		t.Errorf("conf:\n%s", conf)
+	}
+	if err := os.WriteFile(path, []byte(settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the plugin reads the settings back; the command line still wins
+	fs = pluginFlags(t, "-source=stdin", "-key=override")
+	if err := loadConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"source": "stdin", "endpoint": "http://siem:3000/logs", "key": "override", "flush": "100"} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("-%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, content := range []string{"-nosuch=1\n", "endpoint\n", "-flush=many\n", "-config=/etc/other\n"} {
+		path := filepath.Join(t.TempDir(), "collector.flags")
+		if err := os.WriteFile(path, []byte("# comment\n\n"+content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadConfig(pluginFlags(t), path); err == nil || !strings.Contains(err.Error(), ":3:") {
+			t.Errorf("%q: err = %v, want one naming line 3", content, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// httpError is a non-2xx response from the endpoint.
+type httpError struct {
+	status     string
+	code       int
+	retryAfter time.Duration // from Retry-After on 429 and 503
+}
+
+func (e *httpError) Error() string { return fmt.Sprintf("bad status %s", e.status) }
+
+// permanentError is a delivery failure that sending again cannot fix, such
+// as an endpoint that is not a usable URL.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// retryAfter reads a Retry-After header, given either as seconds or as an
+// HTTP date.
+func retryAfter(v string, now time.Time) time.Duration {
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// retryable reports whether a failed delivery may succeed if sent again.
+// Transport errors are, as are timeouts, throttling and server errors; any
+// other status means the endpoint rejected the batch itself.
+func retryable(err error) bool {
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return false
+	}
+	var he *httpError
+	if !errors.As(err, &he) {
+		return true
+	}
+	return he.code == http.StatusRequestTimeout || he.code == http.StatusTooManyRequests || he.code >= 500
+}
+
+// retryPolicy retries failed deliveries with exponential backoff and jitter.
+type retryPolicy struct {
+	attempts  int           // total tries, including the first
+	base, max time.Duration // first and longest wait between tries
+}
+
+// backoff returns the wait before retry n (0-based): base doubled n times,
+// capped at max, with the upper half randomized so that collectors which
+// failed together do not retry together.
+func (p retryPolicy) backoff(n int) time.Duration {
+	d := p.base
+	for range n {
+		if d >= p.max/2 {
+			d = p.max
+			break
+		}
+		d *= 2
+	}
+	d = min(d, p.max)
+	if d <= 1 {
+		return d
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// do calls send until it succeeds, fails permanently, runs out of attempts
+// or ctx is done, returning the last error. A Retry-After longer than max
+// ends the retries early rather than block that long.
+func (p retryPolicy) do(ctx context.Context, send func() error) error {
+	for n := 0; ; n++ {
+		err := send()
+		if err == nil {
+			return nil
+		}
+		if !retryable(err) || n+1 >= p.attempts {
+			return err
+		}
+		wait := p.backoff(n)
+		var he *httpError
+		if errors.As(err, &he) && he.retryAfter > 0 {
+			if he.retryAfter > p.max {
+				return fmt.Errorf("%w (retry after %s)", err, he.retryAfter)
+			}
+			wait = he.retryAfter
+		}
+		log.Printf("send failed (attempt %d of %d): %v; retrying in %s", n+1, p.attempts, err, wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
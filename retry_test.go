@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{attempts: 10, base: 100 * time.Millisecond, max: time.Second}
+	for n, want := range []time.Duration{100, 200, 400, 800, 1000, 1000, 1000} {
+		want *= time.Millisecond
+		for range 50 {
+			// equal jitter: somewhere in the upper half of the wait
+			if d := p.backoff(n); d < want/2 || d >= want {
+				t.Fatalf("backoff(%d) = %s, want in [%s, %s)", n, d, want/2, want)
+			}
+		}
+	}
+	if d := (retryPolicy{base: 1, max: 1}).backoff(3); d != 1 {
+		t.Errorf("backoff with a 1ns cap = %s", d)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		v    string
+		want time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-5", 0},
+		{"Fri, 02 Jan 2026 15:04:35 GMT", 30 * time.Second},
+		{"Fri, 02 Jan 2026 15:00:00 GMT", 0}, // already past
+		{"soon", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.v, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{&httpError{code: http.StatusTooManyRequests}, true},
+		{&httpError{code: http.StatusRequestTimeout}, true},
+		{&httpError{code: http.StatusBadGateway}, true},
+		{&httpError{code: http.StatusBadRequest}, false},
+		{&httpError{code: http.StatusUnauthorized}, false},
+		{&permanentError{errors.New("bad endpoint")}, false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestPostJSONBadEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"ftp://example.com/logs", "example.com/logs", "http://[::1"} {
+		if err := postJSON(endpoint, Batch{}); retryable(err) {
+			t.Errorf("postJSON(%q) = %v, want a permanent error", endpoint, err)
+		}
+	}
+}
+
+func TestPostJSONStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	var he *httpError
+	if err := postJSON(srv.URL, Batch{}); !errors.As(err, &he) || he.code != 503 || he.retryAfter != 7*time.Second {
+		t.Errorf("err = %#v, want 503 with a 7s Retry-After", err)
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := retryPolicy{attempts: 3, base: time.Millisecond, max: 10 * time.Millisecond}
+	ctx := context.Background()
+
+	calls := 0
+	err := p.do(ctx, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("transient failures: %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	err = p.do(ctx, func() error { calls++; return &httpError{code: http.StatusBadRequest} })
+	if err == nil || calls != 1 {
+		t.Errorf("rejected batch: %v after %d calls, want the error after 1", err, calls)
+	}
+
+	calls = 0
+	err = p.do(ctx, func() error { calls++; return errors.New("connection refused") })
+	if err == nil || calls != 3 {
+		t.Errorf("endpoint down: %v after %d calls, want the error after 3", err, calls)
+	}
+
+	// a Retry-After beyond max gives up rather than wait
+	calls = 0
+	err = p.do(ctx, func() error { calls++; return &httpError{code: 429, retryAfter: time.Hour} })
+	if err == nil || calls != 1 {
+		t.Errorf("long Retry-After: %v after %d calls, want the error after 1", err, calls)
+	}
+}
+
+func TestRetryPolicyDoCancelled(t *testing.T) {
+	p := retryPolicy{attempts: 5, base: time.Hour, max: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- p.do(ctx, func() error { calls++; return errors.New("connection refused") })
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || calls != 1 {
+			t.Errorf("%v after %d calls, want the error after 1", err, calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("do kept waiting after the context was cancelled")
+	}
+}
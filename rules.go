@@ -0,0 +1,579 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule is one audit rule as auditctl arguments, e.g.
+// -a always,exit -F arch=b64 -S execve -k collector.
+type Rule []string
+
+// defaultRules is the built-in profile used when no -rules file is given.
+func defaultRules(key string) []Rule {
+	return []Rule{
+		{"-a", "exit,always", "-F", "arch=b64", "-S", "execve", "-k", key},
+		{"-a", "exit,always", "-F", "arch=b64", "-S", "openat", "-k", key},
+		{"-a", "exit,always", "-F", "arch=b64", "-S", "connect", "-k", key},
+	}
+}
+
+// controlOptions are the audit.rules lines that configure the audit system
+// rather than add a rule. The collector leaves those to the host.
+var controlOptions = map[string]bool{
+	"-D": true, "-b": true, "-f": true, "-r": true, "-e": true, "-i": true, "-c": true,
+	"--backlog_wait_time": true, "--loginuid-immutable": true, "--reset-lost": true,
+}
+
+// loadRules reads a file in audit.rules (auditctl) syntax. Control lines are
+// skipped, and rules without a key are tagged with key so the collector can
+// find its own rules and events again.
+func loadRules(path, key string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []Rule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := Rule(strings.Fields(line))
+		if controlOptions[r[0]] {
+			log.Printf("rules: %s:%d: skipping control line %q", path, n, line)
+			continue
+		}
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if len(r.keys()) == 0 {
+			r = append(r, "-k", key)
+		}
+		rules = append(rules, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules", path)
+	}
+	return rules, nil
+}
+
+var (
+	ruleLists   = map[string]bool{"exit": true, "task": true, "user": true, "exclude": true, "filesystem": true, "io_uring": true}
+	ruleActions = map[string]bool{"always": true, "never": true}
+)
+
+// validate checks a rule against the auditctl grammar for adding rules.
+func (r Rule) validate() error {
+	if len(r) < 2 {
+		return fmt.Errorf("incomplete rule %q", strings.Join(r, " "))
+	}
+	syscalls := false
+	switch r[0] {
+	case "-a", "-A":
+		parts := strings.Split(r[1], ",")
+		if len(parts) != 2 {
+			return fmt.Errorf("%s needs list,action, got %q", r[0], r[1])
+		}
+		list, action := parts[0], parts[1]
+		if ruleActions[list] {
+			list, action = action, list
+		}
+		if !ruleLists[list] || !ruleActions[action] {
+			return fmt.Errorf("unknown list or action %q", r[1])
+		}
+		syscalls = list == "exit" || list == "io_uring"
+	case "-w":
+		if !strings.HasPrefix(r[1], "/") {
+			return fmt.Errorf("watch path %q is not absolute", r[1])
+		}
+	default:
+		return fmt.Errorf("unsupported option %q", r[0])
+	}
+	for i := 2; i < len(r); i += 2 {
+		opt := r[i]
+		if i+1 >= len(r) {
+			return fmt.Errorf("%s needs a value", opt)
+		}
+		val := r[i+1]
+		switch opt {
+		case "-S":
+			if !syscalls {
+				return fmt.Errorf("-S is only valid on exit and io_uring rules")
+			}
+		case "-F":
+			if !strings.ContainsAny(val, "=<>&") {
+				return fmt.Errorf("field %q has no operator", val)
+			}
+		case "-C":
+			if !strings.ContainsAny(val, "=") {
+				return fmt.Errorf("comparison %q has no operator", val)
+			}
+		case "-k":
+			if len(val) > 256 {
+				return fmt.Errorf("key %q is too long", val)
+			}
+		case "-p":
+			if r[0] != "-w" || strings.Trim(val, "rwxa") != "" {
+				return fmt.Errorf("bad permissions %q", val)
+			}
+		default:
+			return fmt.Errorf("unsupported option %q", opt)
+		}
+	}
+	return nil
+}
+
+// keys returns the keys set with -k or -F key=.
+func (r Rule) keys() []string {
+	var keys []string
+	for i := 0; i+1 < len(r); i++ {
+		switch {
+		case r[i] == "-k":
+			keys = append(keys, r[i+1])
+		case r[i] == "-F" && strings.HasPrefix(r[i+1], "key="):
+			keys = append(keys, strings.TrimPrefix(r[i+1], "key="))
+		}
+	}
+	return keys
+}
+
+// canonical renders the rule the way `auditctl -l` lists it, with options
+// sorted, so a rule read back from the kernel compares equal to the one
+// that was added.
+func (r Rule) canonical() string {
+	if len(r) < 2 {
+		return strings.Join(r, " ")
+	}
+	head, arg := r[0], r[1]
+	exitList := false
+	switch head {
+	case "-a", "-A", "-d":
+		head = "-a"
+		if parts := strings.Split(arg, ","); len(parts) == 2 {
+			if !ruleActions[parts[0]] {
+				parts[0], parts[1] = parts[1], parts[0]
+			}
+			arg = parts[0] + "," + parts[1]
+			exitList = parts[1] == "exit"
+		}
+	case "-W":
+		head = "-w"
+	}
+	var opts, syscalls []string
+	perm := ""
+	for i := 2; i+1 < len(r); i += 2 {
+		val := r[i+1]
+		switch r[i] {
+		case "-S":
+			syscalls = append(syscalls, strings.Split(val, ",")...)
+		case "-p":
+			perm = val
+		case "-k":
+			opts = append(opts, "-F key="+val)
+		case "-F":
+			k, v, ok := strings.Cut(val, "=")
+			switch {
+			case !ok:
+			case v == "-1" || v == "4294967295":
+				// the unset id is listed either way depending on the version
+				val = k + "=unset"
+			case k == "a0" || k == "a1" || k == "a2" || k == "a3":
+				// syscall arguments are listed in hex
+				if n, err := strconv.ParseUint(v, 0, 64); err == nil {
+					val = fmt.Sprintf("%s=0x%X", k, n)
+				}
+			}
+			opts = append(opts, "-F "+val)
+		default:
+			opts = append(opts, r[i]+" "+val)
+		}
+	}
+	if head == "-w" {
+		if perm == "" {
+			perm = "rwxa"
+		}
+		var p strings.Builder
+		for _, c := range "rwxa" {
+			if strings.ContainsRune(perm, c) {
+				p.WriteRune(c)
+			}
+		}
+		opts = append(opts, "-p "+p.String())
+	}
+	if exitList && len(syscalls) == 0 {
+		syscalls = []string{"all"}
+	}
+	if len(syscalls) > 0 {
+		sort.Strings(syscalls)
+		opts = append(opts, "-S "+strings.Join(syscalls, ","))
+	}
+	sort.Strings(opts)
+	return head + " " + arg + " " + strings.Join(opts, " ")
+}
+
+// deleteArgs returns the auditctl arguments that remove the rule.
+func (r Rule) deleteArgs() []string {
+	d := slices.Clone(r)
+	switch d[0] {
+	case "-a", "-A":
+		d[0] = "-d"
+	case "-w":
+		d[0] = "-W"
+	}
+	return d
+}
+
+// auditctl runs auditctl and returns what it printed.
+var auditctl = func(args ...string) (string, error) {
+	out, err := exec.Command("auditctl", args...).CombinedOutput()
+	return string(out), err
+}
+
+// listRules returns the rules loaded in the kernel, as auditctl -l lists them.
+func listRules() ([]Rule, error) {
+	out, err := auditctl("-l")
+	if err != nil {
+		return nil, fmt.Errorf("auditctl -l: %v: %s", err, strings.TrimSpace(out))
+	}
+	var rules []Rule
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "-") {
+			rules = append(rules, Rule(strings.Fields(line)))
+		}
+	}
+	return rules, nil
+}
+
+// RuleSet adds the collector's rules alongside whatever audit policy the host
+// already has, and removes exactly the rules it added, rather than wiping
+// the kernel's rules with auditctl -D. Failures to load optional rules are
+// only logged.
+type RuleSet struct {
+	mu       sync.Mutex
+	desired  []Rule
+	optional []Rule
+	added    []Rule
+	want     []Rule          // rules in place once Install finished, ours or not
+	last     map[string]bool // canonical rules seen at the last check
+}
+
+func NewRuleSet(rules, optional []Rule) *RuleSet {
+	return &RuleSet{desired: rules, optional: optional}
+}
+
+// Install adds the rules and then checks them against auditctl -l. It
+// returns every problem it found; rules that did load stay loaded.
+func (s *RuleSet) Install() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := exec.LookPath("auditctl"); err != nil {
+		// -source=netlink replaces the daemon but not the userspace tools
+		return fmt.Errorf("loading audit rules needs auditctl, from the audit userspace tools: %w", err)
+	}
+	if out, err := auditctl("-s"); err == nil && slices.Contains(strings.Split(out, "\n"), "enabled 2") {
+		if loaded, err := listRules(); err == nil {
+			s.last = canonicalSet(loaded)
+		}
+		return errors.New("audit rules are locked (enabled 2) until reboot")
+	}
+	existing, err := listRules()
+	if err != nil {
+		return err
+	}
+	have := canonicalSet(existing)
+	log.Printf("rules: %d existing audit rules left in place", len(existing))
+
+	var errs []error
+	add := func(r Rule, required bool) {
+		if have[r.canonical()] {
+			// already loaded by someone else; theirs to remove
+			return
+		}
+		if out, err := auditctl(r...); err != nil {
+			err = fmt.Errorf("auditctl %s: %v: %s", strings.Join(r, " "), err, strings.TrimSpace(out))
+			if required {
+				errs = append(errs, err)
+			} else {
+				log.Printf("rules: optional rule not loaded: %v", err)
+			}
+			return
+		}
+		s.added = append(s.added, r)
+	}
+	for _, r := range s.desired {
+		add(r, true)
+	}
+	for _, r := range s.optional {
+		add(r, false)
+	}
+
+	loaded, err := listRules()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	have = canonicalSet(loaded)
+	s.last = have
+	for _, r := range append(slices.Clone(s.desired), s.optional...) {
+		if have[r.canonical()] {
+			s.want = append(s.want, r)
+		}
+	}
+	optional := canonicalSet(s.optional)
+	for _, r := range s.added {
+		c := r.canonical()
+		switch {
+		case have[c]:
+		case optional[c]:
+			log.Printf("rules: optional rule %q is missing from auditctl -l", strings.Join(r, " "))
+		default:
+			errs = append(errs, fmt.Errorf("rule %q is missing from auditctl -l", strings.Join(r, " ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func canonicalSet(rules []Rule) map[string]bool {
+	set := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		set[r.canonical()] = true
+	}
+	return set
+}
+
+// Remove deletes the rules Install added, newest first.
+func (s *RuleSet) Remove() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.added) - 1; i >= 0; i-- {
+		r := s.added[i]
+		if out, err := auditctl(r.deleteArgs()...); err != nil {
+			log.Printf("rules: remove %q: %v: %s", strings.Join(r, " "), err, strings.TrimSpace(out))
+		}
+	}
+	s.added = nil
+}
+
+// compatTables names the syscall table of the 32-bit ABI a 64-bit kernel
+// also accepts, keyed by GOARCH.
+var compatTables = map[string]string{"amd64": "i386", "arm64": "arm"}
+
+// compatNames maps 64-bit syscall names to the 32-bit ABI's equivalents
+// where it spells them differently or only has a newer variant.
+var compatNames = map[string][]string{
+	"newfstatat": {"fstatat64"}, "stat": {"stat64"}, "lstat": {"lstat64"},
+	"fstat": {"fstat64"}, "mmap": {"mmap2"}, "fcntl": {"fcntl64"},
+	"truncate": {"truncate64"}, "ftruncate": {"ftruncate64"}, "statfs": {"statfs64"},
+	"fstatfs": {"fstatfs64"}, "fadvise64": {"fadvise64_64"}, "sendfile": {"sendfile64"},
+	"accept": {"accept4"}, "getrlimit": {"ugetrlimit"},
+}
+
+// socketCalls can reach an i386 kernel multiplexed through socketcall(2),
+// which older C libraries still use; the value is the call number socketcall
+// takes as its first argument.
+var socketCalls = map[string]int{
+	"socket": 1, "bind": 2, "connect": 3, "listen": 4, "accept": 5, "getsockname": 6,
+	"getpeername": 7, "socketpair": 8, "sendto": 11, "recvfrom": 12, "shutdown": 13,
+	"setsockopt": 14, "getsockopt": 15, "sendmsg": 16, "recvmsg": 17, "accept4": 18,
+	"recvmmsg": 19, "sendmmsg": 20,
+}
+
+// compatRules returns an arch=b32 copy of every arch=b64 rule, with syscall
+// names translated to the compat ABI, so 32-bit processes on a 64-bit host
+// are recorded too. It returns nil on architectures without a compat ABI.
+func compatRules(rules []Rule, goarch string) []Rule {
+	table := syscallTables[compatTables[goarch]]
+	if table == nil {
+		return nil
+	}
+	known := make(map[string]bool, len(table))
+	for _, name := range table {
+		known[name] = true
+	}
+	var out []Rule
+	for _, r := range rules {
+		if !slices.Contains(r, "arch=b64") {
+			continue
+		}
+		var b32 Rule
+		var syscalls []string
+		var calls []int // socketcall call numbers
+		hadSyscalls := false
+		for i := 0; i < len(r); i++ {
+			switch {
+			case r[i] == "-F" && i+1 < len(r) && r[i+1] == "arch=b64":
+				b32 = append(b32, "-F", "arch=b32")
+				i++
+			case r[i] == "-S" && i+1 < len(r):
+				hadSyscalls = true
+				for _, name := range strings.Split(r[i+1], ",") {
+					syscalls = append(syscalls, compatSyscalls(name, known)...)
+					if n, ok := socketCalls[name]; ok && known["socketcall"] {
+						calls = append(calls, n)
+					}
+				}
+				i++
+			default:
+				b32 = append(b32, r[i])
+			}
+		}
+		// -S goes after the arch field, which auditctl needs to resolve names
+		at := slices.Index(b32, "arch=b32") + 1
+		for _, n := range calls {
+			// only the socketcall(2) calls the rule asked for, not all of them
+			sc := slices.Insert(slices.Clone(b32), at, "-S", "socketcall", "-F", fmt.Sprintf("a0=%d", n))
+			out = append(out, sc)
+		}
+		slices.Sort(syscalls)
+		syscalls = slices.Compact(syscalls)
+		if hadSyscalls && len(syscalls) == 0 {
+			if len(calls) == 0 {
+				log.Printf("rules: no 32-bit equivalent for %q", strings.Join(r, " "))
+			}
+			continue
+		}
+		if len(syscalls) > 0 {
+			b32 = slices.Insert(b32, at, "-S", strings.Join(syscalls, ","))
+		}
+		out = append(out, b32)
+	}
+	return out
+}
+
+func compatSyscalls(name string, known map[string]bool) []string {
+	var names []string
+	switch {
+	case name == "all" || known[name]:
+		names = append(names, name)
+	default:
+		for _, alt := range compatNames[name] {
+			if known[alt] {
+				names = append(names, alt)
+			}
+		}
+	}
+	if _, ok := socketCalls[name]; len(names) == 0 && !(ok && known["socketcall"]) {
+		log.Printf("rules: %s has no 32-bit equivalent; not watched for 32-bit processes", name)
+	}
+	return names
+}
+
+// Drift describes how the kernel's rules changed behind the collector's back.
+type Drift struct {
+	Missing []string // collector rules that had been removed
+	Failed  []string // missing rules that could not be reinstalled
+	Added   []string // other rules that appeared
+	Removed []string // other rules that disappeared
+}
+
+// Reconcile compares the kernel's rules with the collector's rules that were
+// in place after Install, whether it added them or found them loaded,
+// reinstalls any that have gone missing, and reports what changed since the
+// last check. It returns nil when nothing changed. The first check after an
+// Install that could not list the rules only takes a baseline.
+func (s *RuleSet) Reconcile() (*Drift, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loaded, err := listRules()
+	if err != nil {
+		return nil, err
+	}
+	cur := canonicalSet(loaded)
+	if s.last == nil {
+		s.last = cur
+		return nil, nil
+	}
+	added := canonicalSet(s.added)
+	ours := make(map[string]bool, len(s.want))
+	var d Drift
+	for _, r := range s.want {
+		c := r.canonical()
+		ours[c] = true
+		if cur[c] {
+			continue
+		}
+		d.Missing = append(d.Missing, strings.Join(r, " "))
+		if out, err := auditctl(r...); err != nil {
+			log.Printf("rules: reinstall %q: %v: %s", strings.Join(r, " "), err, strings.TrimSpace(out))
+			d.Failed = append(d.Failed, strings.Join(r, " "))
+			continue
+		}
+		if !added[c] {
+			// loaded by us now, so ours to remove
+			s.added = append(s.added, r)
+			added[c] = true
+		}
+		cur[c] = true
+	}
+	for c := range cur {
+		if !ours[c] && !s.last[c] {
+			d.Added = append(d.Added, c)
+		}
+	}
+	for c := range s.last {
+		if !ours[c] && !cur[c] {
+			d.Removed = append(d.Removed, c)
+		}
+	}
+	s.last = cur
+	if len(d.Missing)+len(d.Added)+len(d.Removed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	return &d, nil
+}
+
+// driftEvent reports rule drift to the endpoint as possible tampering.
+func driftEvent(d *Drift) Event {
+	ev := Event{
+		Type:       "COLLECTOR_RULE_DRIFT",
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+		TimeSource: "synthesized",
+		Fields:     make(map[string]string),
+	}
+	for k, v := range map[string][]string{
+		"missing":          d.Missing,
+		"reinstall_failed": d.Failed,
+		"added":            d.Added,
+		"removed":          d.Removed,
+	} {
+		if len(v) > 0 {
+			ev.Fields[k] = strings.Join(v, "; ")
+		}
+	}
+	return ev
+}
+
+// statusEvent reports how rule installation went, so the endpoint can tell
+// a host that ships nothing from one that has nothing to ship.
+func statusEvent(status string, installed int, err error) Event {
+	ev := Event{
+		Type:       "COLLECTOR_STATUS",
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+		TimeSource: "synthesized",
+		Fields: map[string]string{
+			"status":          status,
+			"rules_installed": strconv.Itoa(installed),
+		},
+	}
+	if err != nil {
+		ev.Fields["error"] = err.Error()
+	}
+	return ev
+}
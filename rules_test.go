@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeKernel stands in for auditctl, keeping the loaded rules in the form
+// auditctl -l lists them.
+type fakeKernel struct {
+	rules  []string
+	fail   map[string]bool // canonical rules the kernel refuses
+	hide   map[string]bool // canonical rules accepted but never listed
+	locked bool
+	calls  []string
+}
+
+// fakeAuditctl replaces auditctl with k for the duration of the test.
+func fakeAuditctl(t *testing.T, k *fakeKernel) {
+	t.Helper()
+	// Install looks auditctl up on PATH before running it
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "auditctl"), []byte("#!/bin/sh\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	orig := auditctl
+	auditctl = k.run
+	t.Cleanup(func() { auditctl = orig })
+}
+
+func (k *fakeKernel) run(args ...string) (string, error) {
+	k.calls = append(k.calls, strings.Join(args, " "))
+	failed := errors.New("exit status 255")
+	switch args[0] {
+	case "-s":
+		if k.locked {
+			return "enabled 2\nfailure 1\n", nil
+		}
+		return "enabled 1\nfailure 1\n", nil
+	case "-l":
+		if len(k.rules) == 0 {
+			return "No rules\n", nil
+		}
+		return strings.Join(k.rules, "\n") + "\n", nil
+	}
+	if k.locked {
+		return "Error sending add rule data request (Operation not permitted)\n", failed
+	}
+	c := Rule(args).canonical()
+	i := slices.IndexFunc(k.rules, func(l string) bool { return Rule(strings.Fields(l)).canonical() == c })
+	switch args[0] {
+	case "-a", "-A", "-w":
+		if k.fail[c] {
+			return "Error sending add rule data request (Invalid argument)\n", failed
+		}
+		if i >= 0 {
+			return "Error sending add rule data request (Rule exists)\n", failed
+		}
+		if k.hide[c] {
+			return "", nil
+		}
+		if args[0] == "-A" {
+			k.rules = slices.Insert(k.rules, 0, c)
+		} else {
+			k.rules = append(k.rules, c)
+		}
+	case "-d", "-W":
+		if i < 0 {
+			return "Error sending delete rule data request (No such file or directory)\n", failed
+		}
+		k.rules = slices.Delete(k.rules, i, i+1)
+	}
+	return "", nil
+}
+
+func TestSelfRules(t *testing.T) {
+	s := &selfFilter{pid: "42", exe: "/usr/local/bin/collector"}
+	got := s.rules("collector")
+	want := []Rule{{"-A", "never,exit", "-F", "exe=/usr/local/bin/collector", "-k", "collector"}}
+	if len(got) != 1 || !slices.Equal(got[0], want[0]) {
+		t.Errorf("rules = %q, want %q", got, want)
+	}
+	// a pid rule could outlive the collector and hide whoever reuses the pid
+	if s := (&selfFilter{pid: "42"}); s.rules("collector") != nil {
+		t.Errorf("rules without an executable = %q, want none", s.rules("collector"))
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	k := &fakeKernel{rules: []string{
+		"-a never,exit -S all -F pid=4242 -F key=collector",
+		"-a never,exit -S all -F ppid=4242 -F key=collector",
+		"-a never,exit -S all -F exe=/usr/sbin/cron -F key=host",
+		"-a always,exit -F arch=b64 -S execve -F key=collector",
+		"-w /etc/shadow -p wa -k collector",
+	}}
+	fakeAuditctl(t, k)
+	removeStale("collector")
+	want := []string{
+		"-a never,exit -S all -F exe=/usr/sbin/cron -F key=host",
+		"-a always,exit -F arch=b64 -S execve -F key=collector",
+		"-w /etc/shadow -p wa -k collector",
+	}
+	if !slices.Equal(k.rules, want) {
+		t.Errorf("rules left:\n%s\nwant:\n%s", strings.Join(k.rules, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRuleSetKeepsHostRules(t *testing.T) {
+	host := []string{
+		"-a always,exit -F arch=b64 -S execve -F key=host",
+		"-w /etc/passwd -p wa -k identity",
+		// someone else's copy of one of ours
+		"-a always,exit -F arch=b64 -S openat -F key=collector",
+	}
+	k := &fakeKernel{rules: slices.Clone(host)}
+	fakeAuditctl(t, k)
+	s := NewRuleSet([]Rule{
+		{"-a", "always,exit", "-F", "arch=b64", "-S", "execve", "-k", "collector"},
+		{"-a", "always,exit", "-F", "arch=b64", "-S", "openat", "-k", "collector"},
+		{"-w", "/etc/shadow", "-p", "wa", "-k", "collector"},
+	}, nil)
+	if err := s.Install(); err != nil {
+		t.Fatal(err)
+	}
+	if len(k.rules) != 5 || len(s.added) != 2 {
+		t.Fatalf("%d rules loaded, %d added by us; want 5 and 2", len(k.rules), len(s.added))
+	}
+
+	s.Remove()
+	if !slices.Equal(k.rules, host) {
+		t.Errorf("rules after Remove:\n%s\nwant the host's:\n%s", strings.Join(k.rules, "\n"), strings.Join(host, "\n"))
+	}
+	for _, c := range k.calls {
+		if c == "-D" {
+			t.Error("flushed every rule with auditctl -D")
+		}
+	}
+	// removed newest first
+	if n := len(k.calls); n < 2 || k.calls[n-2] != "-W /etc/shadow -p wa -k collector" || !strings.HasPrefix(k.calls[n-1], "-d always,exit") {
+		t.Errorf("calls = %q", k.calls)
+	}
+}
+
+func TestCanonicalMatchesAuditctlList(t *testing.T) {
+	// rules as added, and as auditctl -l lists them back
+	tests := []struct{ added, listed string }{
+		{"-a always,exit -F arch=b64 -S execve -k collector", "-a always,exit -F arch=b64 -S execve -F key=collector"},
+		{"-a exit,always -F arch=b64 -S openat,open -k collector", "-a always,exit -F arch=b64 -S open,openat -F key=collector"},
+		{"-A never,exit -F exe=/usr/sbin/collector -k collector", "-a never,exit -S all -F exe=/usr/sbin/collector -F key=collector"},
+		{"-a always,exit -F arch=b32 -S socketcall -F a0=3 -k collector", "-a always,exit -F arch=b32 -S socketcall -F a0=0x3 -F key=collector"},
+		{"-a always,exit -F arch=b64 -S ioctl -F a1=21505 -k tty", "-a always,exit -F arch=b64 -S ioctl -F a1=0x5401 -F key=tty"},
+		{"-a always,exit -F arch=b64 -S execve -F auid>=1000 -F auid!=-1 -k exec", "-a always,exit -F arch=b64 -S execve -F auid>=1000 -F auid!=unset -F key=exec"},
+		{"-a always,exit -F arch=b64 -S execve -F auid!=4294967295", "-a always,exit -F arch=b64 -S execve -F auid!=unset"},
+		{"-w /etc/shadow -p aw -k identity", "-w /etc/shadow -p wa -k identity"},
+		{"-w /etc/sudoers", "-w /etc/sudoers -p rwxa"},
+	}
+	for _, tt := range tests {
+		added, listed := Rule(strings.Fields(tt.added)), Rule(strings.Fields(tt.listed))
+		if added.canonical() != listed.canonical() {
+			t.Errorf("%q and %q differ:\n%s\n%s", tt.added, tt.listed, added.canonical(), listed.canonical())
+		}
+	}
+	a, b := Rule{"-w", "/etc/shadow", "-p", "wa"}, Rule{"-w", "/etc/shadow", "-p", "r"}
+	if a.canonical() == b.canonical() {
+		t.Errorf("%q matches %q", a, b)
+	}
+}
+
+func TestCompatRules(t *testing.T) {
+	rules := []Rule{
+		{"-a", "always,exit", "-F", "arch=b64", "-S", "connect", "-k", "collector"},
+		{"-a", "always,exit", "-F", "arch=b64", "-S", "execve,newfstatat", "-F", "auid>=1000", "-k", "collector"},
+		{"-w", "/etc/shadow", "-p", "wa", "-k", "collector"},
+	}
+	got := compatRules(rules, "amd64")
+	want := []Rule{
+		{"-a", "always,exit", "-F", "arch=b32", "-S", "socketcall", "-F", "a0=3", "-k", "collector"},
+		{"-a", "always,exit", "-F", "arch=b32", "-S", "connect", "-k", "collector"},
+		{"-a", "always,exit", "-F", "arch=b32", "-S", "execve,fstatat64", "-F", "auid>=1000", "-k", "collector"},
+	}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("compatRules:\n%q\nwant:\n%q", got, want)
+	}
+	if got := compatRules(rules, "riscv64"); got != nil {
+		t.Errorf("riscv64 has no compat ABI, got %q", got)
+	}
+}
+
+func TestRuleSetInstallVerifies(t *testing.T) {
+	execve := Rule{"-a", "always,exit", "-F", "arch=b64", "-S", "execve", "-k", "collector"}
+	openat := Rule{"-a", "always,exit", "-F", "arch=b64", "-S", "openat", "-k", "collector"}
+	connect := Rule{"-a", "always,exit", "-F", "arch=b64", "-S", "connect", "-k", "collector"}
+	b32 := Rule{"-a", "always,exit", "-F", "arch=b32", "-S", "execve", "-k", "collector"}
+	b32open := Rule{"-a", "always,exit", "-F", "arch=b32", "-S", "openat", "-k", "collector"}
+	k := &fakeKernel{
+		fail: map[string]bool{openat.canonical(): true, b32.canonical(): true},
+		hide: map[string]bool{connect.canonical(): true, b32open.canonical(): true},
+	}
+	fakeAuditctl(t, k)
+	s := NewRuleSet([]Rule{execve, openat, connect}, []Rule{b32, b32open})
+	err := s.Install()
+	if err == nil {
+		t.Fatal("Install reported success")
+	}
+	// the refused and the silently missing required rules are errors; the
+	// optional ones are only logged
+	msg := err.Error()
+	if !strings.Contains(msg, "openat") || !strings.Contains(msg, "connect") || strings.Contains(msg, "arch=b32") {
+		t.Errorf("err = %v", err)
+	}
+	if len(s.want) != 1 || !slices.Equal(s.want[0], execve) {
+		t.Errorf("want = %q, want only the execve rule", s.want)
+	}
+	// what did load stays loaded until Remove
+	if len(k.rules) != 1 {
+		t.Errorf("rules loaded = %q", k.rules)
+	}
+	s.Remove()
+	if len(k.rules) != 0 {
+		t.Errorf("rules left after Remove = %q", k.rules)
+	}
+}
+
+func TestRuleSetInstallLocked(t *testing.T) {
+	k := &fakeKernel{rules: []string{"-a always,exit -F arch=b64 -S execve -F key=host"}, locked: true}
+	fakeAuditctl(t, k)
+	s := NewRuleSet([]Rule{{"-a", "always,exit", "-F", "arch=b64", "-S", "openat", "-k", "collector"}}, nil)
+	if err := s.Install(); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("err = %v, want the rules reported locked", err)
+	}
+	if len(s.added) != 0 || len(s.last) != 1 {
+		t.Errorf("added %q, baseline %v", s.added, s.last)
+	}
+}
+
+func TestRuleSetInstallNoAuditctl(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if err := NewRuleSet(defaultRules("collector"), nil).Install(); err == nil || !strings.Contains(err.Error(), "auditctl") {
+		t.Errorf("err = %v, want auditctl reported missing", err)
+	}
+}
+
+func TestRuleSetReconcile(t *testing.T) {
+	host := "-a always,exit -F arch=b64 -S execve -F key=host"
+	theirs := "-a always,exit -F arch=b64 -S openat -F key=collector"
+	k := &fakeKernel{rules: []string{host, theirs}}
+	fakeAuditctl(t, k)
+	execve := Rule{"-a", "always,exit", "-F", "arch=b64", "-S", "execve", "-k", "collector"}
+	openat := Rule{"-a", "always,exit", "-F", "arch=b64", "-S", "openat", "-k", "collector"}
+	s := NewRuleSet([]Rule{execve, openat}, nil)
+	if err := s.Install(); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := s.Reconcile(); d != nil || err != nil {
+		t.Fatalf("no change: %+v, %v", d, err)
+	}
+
+	// someone deletes both our rules and the host's, and adds one
+	k.rules = []string{"-w /etc/hosts -p wa -k net"}
+	d, err := s.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil {
+		t.Fatal("no drift reported")
+	}
+	if len(d.Missing) != 2 || len(d.Failed) != 0 {
+		t.Errorf("missing %q, failed %q; want both ours reinstalled", d.Missing, d.Failed)
+	}
+	if !slices.Equal(d.Added, []string{Rule(strings.Fields("-w /etc/hosts -p wa -k net")).canonical()}) {
+		t.Errorf("added = %q", d.Added)
+	}
+	if !slices.Equal(d.Removed, []string{Rule(strings.Fields(host)).canonical()}) {
+		t.Errorf("removed = %q", d.Removed)
+	}
+	if len(k.rules) != 3 {
+		t.Errorf("rules after reconcile = %q", k.rules)
+	}
+	// the openat rule was someone else's, but we loaded it back, so it is
+	// ours to remove now
+	if len(s.added) != 2 {
+		t.Errorf("added = %q, want both rules", s.added)
+	}
+	if d, err := s.Reconcile(); d != nil || err != nil {
+		t.Errorf("after repair: %+v, %v", d, err)
+	}
+
+	// a rule that cannot be put back is reported, and tried again next time
+	k.rules = k.rules[:1]
+	k.fail = map[string]bool{execve.canonical(): true}
+	if d, _ := s.Reconcile(); d == nil || len(d.Failed) != 1 {
+		t.Errorf("drift = %+v, want one failed reinstall", d)
+	}
+	d, _ = s.Reconcile()
+	if d == nil || len(d.Missing) != 1 || len(d.Failed) != 1 {
+		t.Fatalf("drift = %+v, want the rule still missing", d)
+	}
+	ev := driftEvent(d)
+	if ev.Type != "COLLECTOR_RULE_DRIFT" || ev.Fields["reinstall_failed"] != strings.Join(execve, " ") {
+		t.Errorf("drift event = %+v", ev)
+	}
+}
+
+func TestRuleSetReconcileBaseline(t *testing.T) {
+	k := &fakeKernel{rules: []string{"-w /etc/hosts -p wa -k net"}}
+	fakeAuditctl(t, k)
+	// as after an Install that could not list the rules
+	s := NewRuleSet(defaultRules("collector"), nil)
+	if d, err := s.Reconcile(); d != nil || err != nil {
+		t.Errorf("first reconcile = %+v, %v; want a quiet baseline", d, err)
+	}
+	k.rules = nil
+	if d, _ := s.Reconcile(); d == nil || len(d.Removed) != 1 || len(d.Missing) != 0 {
+		t.Errorf("drift = %+v, want only the hosts watch removed", d)
+	}
+}
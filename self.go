@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// selfFilter recognizes events caused by the collector itself: its reads of
+// audit.log, its connections to the endpoint and the auditctl processes it
+// runs. Shipping those would generate more of them with every batch.
+type selfFilter struct {
+	pid        string
+	exe        string
+	suppressed atomic.Int64
+}
+
+func newSelfFilter() *selfFilter {
+	s := &selfFilter{pid: strconv.Itoa(os.Getpid())}
+	if exe, err := os.Executable(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			exe = resolved
+		}
+		s.exe = exe
+	}
+	return s
+}
+
+// rules returns an audit rule that stops the kernel from recording the
+// collector. It is prepended with -A because the first matching exit rule
+// wins, including any rules the host already has. The rule matches the
+// executable rather than the pid, which a later process could reuse if the
+// collector dies without removing it; the auditctl processes it runs are
+// left to drop.
+func (s *selfFilter) rules(key string) []Rule {
+	if s.exe == "" {
+		return nil
+	}
+	return []Rule{{"-A", "never,exit", "-F", "exe=" + s.exe, "-k", key}}
+}
+
+// removeStale deletes never rules carrying key that an earlier run left
+// behind, such as the pid rules older versions added, which would silence
+// whichever process reuses the pid.
+func removeStale(key string) {
+	loaded, err := listRules()
+	if err != nil {
+		// Install runs into it too and says why
+		return
+	}
+	for _, r := range loaded {
+		if len(r) < 2 || r[0] != "-a" || !slices.Contains(strings.Split(r[1], ","), "never") || !slices.Contains(r.keys(), key) {
+			continue
+		}
+		if out, err := auditctl(r.deleteArgs()...); err != nil {
+			log.Printf("rules: remove stale %q: %v: %s", strings.Join(r, " "), err, strings.TrimSpace(out))
+			continue
+		}
+		log.Printf("rules: removed stale rule %q", strings.Join(r, " "))
+	}
+}
+
+// drop reports whether any record of ev comes from the collector, counting
+// the event as suppressed if so.
+func (s *selfFilter) drop(ev Event) bool {
+	for _, r := range append([]Event{ev}, ev.Records...) {
+		exe, ok := r.Decoded["exe"]
+		if !ok {
+			exe = r.Fields["exe"]
+		}
+		if r.Fields["pid"] == s.pid || r.Fields["ppid"] == s.pid || s.exe != "" && exe == s.exe {
+			s.suppressed.Add(1)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net/netip"
+)
+
+// Sockaddr is the decoded saddr of a SOCKADDR record.
+type Sockaddr struct {
+	Family string `json:"family"`
+	Addr   string `json:"addr,omitempty"`
+	Port   uint16 `json:"port,omitempty"`
+	Path   string `json:"path,omitempty"` // AF_UNIX; abstract names start with @
+}
+
+const (
+	afUnix  = 1
+	afInet  = 2
+	afInet6 = 10
+)
+
+// decodeSockaddr decodes the hex saddr field, a raw struct sockaddr as the
+// kernel saw it: the family in host byte order followed by the
+// family-specific address, with ports in network byte order.
+func decodeSockaddr(saddr string) (*Sockaddr, bool) {
+	b, err := hex.DecodeString(saddr)
+	if err != nil || len(b) < 2 {
+		return nil, false
+	}
+	switch binary.NativeEndian.Uint16(b) {
+	case afUnix:
+		path := b[2:]
+		if len(path) > 0 && path[0] == 0 {
+			// abstract socket; shown as @name like ss and netstat do
+			return &Sockaddr{Family: "unix", Path: "@" + string(bytes.TrimRight(path[1:], "\x00"))}, true
+		}
+		if i := bytes.IndexByte(path, 0); i >= 0 {
+			path = path[:i]
+		}
+		return &Sockaddr{Family: "unix", Path: string(path)}, true
+	case afInet:
+		if len(b) < 8 {
+			return nil, false
+		}
+		addr := netip.AddrFrom4([4]byte(b[4:8]))
+		return &Sockaddr{Family: "inet", Addr: addr.String(), Port: binary.BigEndian.Uint16(b[2:])}, true
+	case afInet6:
+		if len(b) < 24 {
+			return nil, false
+		}
+		addr := netip.AddrFrom16([16]byte(b[8:24]))
+		return &Sockaddr{Family: "inet6", Addr: addr.String(), Port: binary.BigEndian.Uint16(b[2:])}, true
+	}
+	return nil, false
+}
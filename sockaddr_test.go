@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeSockaddr(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("saddr samples are from a little-endian host")
+	}
+	tests := []struct {
+		name  string
+		saddr string
+		want  *Sockaddr
+	}{
+		{
+			name:  "inet",
+			saddr: "020000357F0000010000000000000000",
+			want:  &Sockaddr{Family: "inet", Addr: "127.0.0.1", Port: 53},
+		},
+		{
+			name:  "inet6",
+			saddr: "0A0001BB000000000000000000000000000000000000000100000000",
+			want:  &Sockaddr{Family: "inet6", Addr: "::1", Port: 443},
+		},
+		{
+			name:  "unix",
+			saddr: "01002F72756E2F73797374656D642F6E6F7469667900",
+			want:  &Sockaddr{Family: "unix", Path: "/run/systemd/notify"},
+		},
+		{
+			name:  "abstract unix",
+			saddr: "010000666F6F",
+			want:  &Sockaddr{Family: "unix", Path: "@foo"},
+		},
+		{name: "netlink", saddr: "100000000000000000000000"},
+		{name: "short inet", saddr: "02000035"},
+		{name: "too short", saddr: "02"},
+		{name: "not hex", saddr: "zz00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeSockaddr(tt.saddr)
+			if ok != (tt.want != nil) {
+				t.Fatalf("decodeSockaddr ok = %v, want %v", ok, tt.want != nil)
+			}
+			if ok && *got != *tt.want {
+				t.Errorf("decodeSockaddr = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Spool is an on-disk queue for batches the endpoint would not take. Batches
+// are appended as JSON lines to numbered segment files; a segment is closed
+// once it reaches segSize, and the oldest are deleted, undelivered or not,
+// to keep the spool under maxSize. A cursor file records how far delivery
+// has got, so a restart picks up where the last run left off.
+type Spool struct {
+	mu      sync.Mutex
+	dir     string
+	segSize int64
+	maxSize int64
+	segs    []spoolSeg // oldest first; the last is the one being written
+	w       *os.File
+	cur     spoolCursor
+	wake    chan struct{}
+}
+
+type spoolSeg struct {
+	n    uint64
+	size int64
+}
+
+// spoolCursor is the next batch to deliver: Offset bytes into segment Seg.
+type spoolCursor struct {
+	Seg    uint64 `json:"segment"`
+	Offset int64  `json:"offset"`
+}
+
+// OpenSpool opens or creates the spool in dir, returning nil without error
+// when dir is empty.
+func OpenSpool(dir string, segSize, maxSize int64) (*Spool, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, segSize: segSize, maxSize: maxSize, wake: make(chan struct{}, 1)}
+	names, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		var n uint64
+		if _, err := fmt.Sscanf(filepath.Base(name), "%d.seg", &n); err != nil {
+			continue
+		}
+		fi, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		s.segs = append(s.segs, spoolSeg{n: n, size: fi.Size()})
+	}
+	slices.SortFunc(s.segs, func(a, b spoolSeg) int { return cmp.Compare(a.n, b.n) })
+
+	data, err := os.ReadFile(s.cursorPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.cur); err != nil {
+			return nil, fmt.Errorf("spool cursor: %w", err)
+		}
+	}
+	// segments before the cursor were delivered but not yet deleted
+	for len(s.segs) > 0 && s.segs[0].n < s.cur.Seg {
+		s.remove(0)
+	}
+	if len(s.segs) > 0 && s.segs[0].n != s.cur.Seg {
+		s.cur = spoolCursor{Seg: s.segs[0].n}
+	}
+	if len(s.segs) > 0 {
+		log.Printf("spool: %d bytes in %d segments waiting to be delivered", s.size(), len(s.segs))
+	}
+	return s, nil
+}
+
+func (s *Spool) segPath(n uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d.seg", n))
+}
+
+func (s *Spool) cursorPath() string { return filepath.Join(s.dir, "cursor.json") }
+
+func (s *Spool) size() int64 {
+	var total int64
+	for _, seg := range s.segs {
+		total += seg.size
+	}
+	return total
+}
+
+// Pending reports whether any batch is waiting in the spool.
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segs) > 0
+}
+
+// Put appends a batch and syncs it to disk.
+func (s *Spool) Put(b Batch) error {
+	line, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w != nil && s.segs[len(s.segs)-1].size >= s.segSize {
+		s.w.Close()
+		s.w = nil
+	}
+	if s.w == nil {
+		var n uint64 = 1
+		if len(s.segs) > 0 {
+			n = s.segs[len(s.segs)-1].n + 1
+		}
+		f, err := os.OpenFile(s.segPath(n), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		s.w = f
+		s.segs = append(s.segs, spoolSeg{n: n})
+		if len(s.segs) == 1 {
+			s.cur = spoolCursor{Seg: n}
+		}
+	}
+	last := &s.segs[len(s.segs)-1]
+	if _, err := s.w.Write(line); err != nil {
+		// the segment may hold a partial line now; start a new one next time
+		s.w.Close()
+		s.w = nil
+		last.size = s.segSize
+		return err
+	}
+	last.size += int64(len(line))
+	if err := s.w.Sync(); err != nil {
+		return err
+	}
+	for len(s.segs) > 1 && s.size() > s.maxSize {
+		log.Printf("spool: over %d bytes; discarding oldest segment (%d bytes)", s.maxSize, s.segs[0].size)
+		s.remove(0)
+		s.cur = spoolCursor{Seg: s.segs[0].n}
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// remove deletes segment i. The caller holds mu.
+func (s *Spool) remove(i int) {
+	if i == len(s.segs)-1 && s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+	if err := os.Remove(s.segPath(s.segs[i].n)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("spool: %v", err)
+	}
+	s.segs = slices.Delete(s.segs, i, i+1)
+}
+
+// next reads the batch at the cursor, deleting segments that have been read
+// to the end. It reports false when the spool is empty.
+func (s *Spool) next() (Batch, spoolCursor, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.segs) > 0 {
+		seg := s.segs[0]
+		if s.cur.Offset < seg.size {
+			b, n, err := s.read(seg.n, s.cur.Offset)
+			if err == nil {
+				return b, spoolCursor{Seg: seg.n, Offset: s.cur.Offset + n}, true
+			}
+			log.Printf("spool: segment %d at %d: %v; skipping the rest of it", seg.n, s.cur.Offset, err)
+		}
+		s.remove(0)
+		if len(s.segs) > 0 {
+			s.cur = spoolCursor{Seg: s.segs[0].n}
+		} else {
+			s.cur = spoolCursor{}
+		}
+		s.saveCursor()
+	}
+	return Batch{}, spoolCursor{}, false
+}
+
+// read decodes the line at off in segment n and returns its length.
+func (s *Spool) read(n uint64, off int64) (Batch, int64, error) {
+	f, err := os.Open(s.segPath(n))
+	if err != nil {
+		return Batch{}, 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return Batch{}, 0, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return Batch{}, 0, err
+	}
+	var b Batch
+	if err := json.Unmarshal(line, &b); err != nil {
+		return Batch{}, 0, err
+	}
+	return b, int64(len(line)), nil
+}
+
+// advance moves the cursor past a delivered batch, unless its segment was
+// discarded in the meantime.
+func (s *Spool) advance(to spoolCursor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cur.Seg != to.Seg {
+		return
+	}
+	s.cur = to
+	s.saveCursor()
+}
+
+// saveCursor persists the cursor. The caller holds mu.
+func (s *Spool) saveCursor() {
+	data, err := json.Marshal(s.cur)
+	if err != nil {
+		return
+	}
+	tmp := s.cursorPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("spool: cursor save: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, s.cursorPath()); err != nil {
+		log.Printf("spool: cursor save: %v", err)
+	}
+}
+
+// Drain replays spooled batches through send, oldest first, until ctx is
+// done. A batch that still fails is tried again after wait; one the
+// endpoint rejects outright is dropped.
+func (s *Spool) Drain(ctx context.Context, send func(Batch) error, wait time.Duration) {
+	for ctx.Err() == nil {
+		b, to, ok := s.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			}
+			continue
+		}
+		if err := send(b); err != nil {
+			if retryable(err) {
+				log.Printf("spool: send failed: %v; retrying in %s", err, wait)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+				continue
+			}
+			log.Printf("spool: endpoint rejected a batch of %d events, dropping it: %v", len(b.Logs), err)
+		}
+		s.advance(to)
+	}
+}
+
+// Close closes the segment being written.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	err := s.w.Close()
+	s.w = nil
+	return err
+}
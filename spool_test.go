@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func spoolBatch(serial uint64) Batch {
+	return Batch{Timestamp: time.Unix(1700000000, 0).UTC(), Logs: []Event{{Type: "SYSCALL", Serial: serial}}}
+}
+
+func TestSpoolRestartFromCursor(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range uint64(4) {
+		if err := s.Put(spoolBatch(i + 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// deliver two, then stop as a crash or restart would
+	sent := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Drain(ctx, func(b Batch) error {
+		if sent++; sent == 2 {
+			cancel()
+		}
+		return nil
+	}, time.Millisecond)
+	s.Close()
+
+	s, err = OpenSpool(dir, 1<<20, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if !s.Pending() {
+		t.Fatal("reopened spool has nothing pending")
+	}
+	var got []uint64
+	for {
+		b, to, ok := s.next()
+		if !ok {
+			break
+		}
+		got = append(got, b.Logs[0].Serial)
+		s.advance(to)
+	}
+	if len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Errorf("after restart sent %v, want [3 4]", got)
+	}
+	if s.Pending() {
+		t.Error("spool still pending after delivering everything")
+	}
+}
+
+func TestSpoolEviction(t *testing.T) {
+	dir := t.TempDir()
+	line, _ := json.Marshal(spoolBatch(1))
+	seg := int64(len(line) + 1)
+	// one batch per segment, room for three
+	s, err := OpenSpool(dir, seg, 3*seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := range uint64(5) {
+		if err := s.Put(spoolBatch(i + 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(names) != 3 {
+		t.Errorf("%d segment files, want 3", len(names))
+	}
+	var got []uint64
+	for {
+		b, to, ok := s.next()
+		if !ok {
+			break
+		}
+		got = append(got, b.Logs[0].Serial)
+		s.advance(to)
+	}
+	// the oldest were discarded undelivered
+	if len(got) != 3 || got[0] != 3 || got[2] != 5 {
+		t.Errorf("delivered %v, want [3 4 5]", got)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(names) != 0 {
+		t.Errorf("delivered segments left behind: %v", names)
+	}
+}
+
+func TestSpoolDrainRetries(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), 1<<20, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Put(spoolBatch(1))
+	s.Put(spoolBatch(2))
+	s.Put(spoolBatch(3))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got []uint64
+	fails := 2
+	s.Drain(ctx, func(b Batch) error {
+		switch serial := b.Logs[0].Serial; {
+		case serial == 1 && fails > 0:
+			// the endpoint is down; the head batch waits
+			fails--
+			return errors.New("connection refused")
+		case serial == 2:
+			// rejected for good; dropped rather than retried forever
+			return &httpError{status: "400 Bad Request", code: 400}
+		case serial == 3:
+			cancel()
+		}
+		got = append(got, b.Logs[0].Serial)
+		return nil
+	}, time.Millisecond)
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 || fails != 0 {
+		t.Errorf("delivered %v with %d failures left, want [1 3] and 0", got, fails)
+	}
+}
+
+func TestOpenSpoolDisabled(t *testing.T) {
+	if s, err := OpenSpool("", 1, 1); s != nil || err != nil {
+		t.Errorf("OpenSpool(\"\") = %v, %v", s, err)
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "cursor.json"), []byte("{"), 0o600)
+	if _, err := OpenSpool(dir, 1, 1); err == nil {
+		t.Error("corrupt cursor accepted")
+	}
+}
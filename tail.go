@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Tailer follows a file the way `tail -F` does: it keeps reading the open
+// file, switches to the new file once the path is renamed away (rotation),
+// and rewinds when the file is truncated. It tracks the inode and offset of
+// the data it has returned so callers know where they are in the log.
+type Tailer struct {
+	ctx    context.Context
+	path   string
+	poll   time.Duration
+	whence int        // where to start in the first file opened
+	queue  []*os.File // rotated files to finish before the live path
+
+	f   *os.File
+	dev uint64
+	ino uint64
+	off int64
+
+	read int64     // total bytes returned by Read
+	segs []segment // where each opened file starts in the returned stream
+}
+
+type segment struct {
+	start    int64
+	dev, ino uint64
+	off      int64
+}
+
+func NewTailer(ctx context.Context, path string) *Tailer {
+	return &Tailer{ctx: ctx, path: path, poll: 250 * time.Millisecond, whence: io.SeekEnd}
+}
+
+// Resume positions the tailer at a saved checkpoint instead of the end of the
+// file. If the checkpointed file has been rotated since, the rotated copy is
+// read from the checkpoint offset, followed by any newer rotations and then
+// the live file, so nothing written while the collector was down is skipped.
+func (t *Tailer) Resume(cp Checkpoint) error {
+	t.whence = io.SeekStart
+	if fi, err := os.Stat(t.path); err == nil {
+		if dev, ino := fileID(fi); dev == cp.Device && ino == cp.Inode {
+			if fi.Size() < cp.Offset {
+				log.Printf("tail: %s is shorter than the checkpoint; reading from start", t.path)
+				return nil
+			}
+			return t.openAt(t.path, cp.Offset)
+		}
+	}
+	// auditd rotates audit.log to audit.log.1, audit.log.1 to audit.log.2 and
+	// so on; newer rotations have smaller numbers
+	var newer []*os.File
+	for i := 1; ; i++ {
+		f, err := os.Open(fmt.Sprintf("%s.%d", t.path, i))
+		if err != nil {
+			break
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			break
+		}
+		if dev, ino := fileID(fi); dev == cp.Device && ino == cp.Inode {
+			if _, err := f.Seek(cp.Offset, io.SeekStart); err != nil {
+				f.Close()
+				break
+			}
+			t.queue = append([]*os.File{f}, newer...)
+			log.Printf("tail: resuming from rotated %s", f.Name())
+			return nil
+		}
+		newer = append([]*os.File{f}, newer...)
+	}
+	for _, f := range newer {
+		f.Close()
+	}
+	log.Printf("tail: checkpointed file is gone; events after serial %d may be missing", cp.Serial)
+	return nil
+}
+
+// Read blocks until data is available and never returns 0, nil. It returns
+// io.EOF once the context is cancelled.
+func (t *Tailer) Read(p []byte) (int, error) {
+	for {
+		if t.ctx.Err() != nil {
+			// stop even while the log is busy so shutdown is not held up
+			return 0, io.EOF
+		}
+		if t.f == nil {
+			if len(t.queue) > 0 {
+				if err := t.use(t.queue[0]); err != nil {
+					return 0, err
+				}
+				t.queue = t.queue[1:]
+				continue
+			}
+			err := t.open(t.whence)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return 0, err
+			}
+			// a file that appears later was created after we started
+			t.whence = io.SeekStart
+		}
+		if t.f != nil {
+			n, err := t.f.Read(p)
+			t.off += int64(n)
+			t.read += int64(n)
+			if n > 0 {
+				return n, nil
+			}
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			if len(t.queue) > 0 || t.f.Name() != t.path {
+				// finished a rotated file from Resume; move to the next
+				t.f.Close()
+				t.f = nil
+				continue
+			}
+			moved, err := t.checkRotate()
+			if err != nil {
+				return 0, err
+			}
+			if moved {
+				continue
+			}
+		}
+		select {
+		case <-t.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(t.poll):
+		}
+	}
+}
+
+// Position maps a count of bytes consumed from Read to the file and offset
+// they end at. consumed must not decrease between calls.
+func (t *Tailer) Position(consumed int64) Checkpoint {
+	i := len(t.segs) - 1
+	for i > 0 && t.segs[i].start > consumed {
+		i--
+	}
+	if i < 0 {
+		return Checkpoint{}
+	}
+	t.segs = t.segs[i:]
+	s := t.segs[0]
+	return Checkpoint{Device: s.dev, Inode: s.ino, Offset: s.off + consumed - s.start}
+}
+
+func (t *Tailer) Close() error {
+	for _, f := range t.queue {
+		f.Close()
+	}
+	t.queue = nil
+	if t.f == nil {
+		return nil
+	}
+	return t.f.Close()
+}
+
+// open opens the path and positions the read offset relative to whence.
+func (t *Tailer) open(whence int) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, whence); err != nil {
+		f.Close()
+		return err
+	}
+	return t.use(f)
+}
+
+func (t *Tailer) openAt(name string, off int64) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	return t.use(f)
+}
+
+// use makes f, already positioned, the file being read.
+func (t *Tailer) use(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if t.f != nil {
+		t.f.Close()
+	}
+	t.f = f
+	t.dev, t.ino = fileID(fi)
+	t.off = off
+	t.segs = append(t.segs, segment{start: t.read, dev: t.dev, ino: t.ino, off: off})
+	return nil
+}
+
+// checkRotate is called at EOF. It reports whether the read position moved,
+// either because the path now names a new file or because the current file
+// was truncated.
+func (t *Tailer) checkRotate() (bool, error) {
+	fi, err := os.Stat(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// mid-rotation: keep the old file until the new one appears
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if dev, ino := fileID(fi); dev != t.dev || ino != t.ino {
+		// drain whatever was written to the old file before it was renamed
+		if cur, err := t.f.Stat(); err == nil && cur.Size() > t.off {
+			return true, nil
+		}
+		log.Printf("tail: %s rotated; following new file", t.path)
+		return true, t.open(io.SeekStart)
+	}
+	if fi.Size() < t.off {
+		log.Printf("tail: %s truncated; reading from start", t.path)
+		off, err := t.f.Seek(0, io.SeekStart)
+		if err != nil {
+			return false, err
+		}
+		t.off = off
+		t.segs = append(t.segs, segment{start: t.read, dev: t.dev, ino: t.ino, off: off})
+		return true, nil
+	}
+	return false, nil
+}
+
+func fileID(fi os.FileInfo) (dev, ino uint64) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Dev), uint64(st.Ino)
+	}
+	return 0, 0
+}
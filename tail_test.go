@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTailer(t *testing.T, path string) *Tailer {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	tl := NewTailer(ctx, path)
+	tl.poll = time.Millisecond
+	// the tailer opens the file on the first read, which would race the
+	// writes the tests make before it
+	tl.whence = io.SeekStart
+	t.Cleanup(func() { tl.Close() })
+	return tl
+}
+
+// readString reads from the tailer until it has len(want) bytes.
+func readString(t *testing.T, tl *Tailer, want string) {
+	t.Helper()
+	var got []byte
+	buf := make([]byte, 64)
+	for len(got) < len(want) {
+		n, err := tl.Read(buf[:min(len(buf), len(want)-len(got))])
+		if err != nil {
+			t.Fatalf("read %q, then %v; want %q", got, err, want)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if string(got) != want {
+		t.Fatalf("read %q, want %q", got, want)
+	}
+}
+
+func appendFile(t *testing.T, name, s string) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func inode(t *testing.T, name string) uint64 {
+	t.Helper()
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ino := fileID(fi)
+	return ino
+}
+
+func TestTailerFollowsRename(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	tl := newTestTailer(t, path)
+	appendFile(t, path, "a1\n")
+	readString(t, tl, "a1\n")
+	first := inode(t, path)
+
+	// auditd renames the log, may still flush to it, then starts a new one
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "a2\n")
+	appendFile(t, path, "b1\n")
+	readString(t, tl, "a2\nb1\n")
+
+	if cp := tl.Position(5); cp.Inode != first || cp.Offset != 5 {
+		t.Errorf("position in the old file = %+v, want inode %d, offset 5", cp, first)
+	}
+	if cp := tl.Position(9); cp.Inode != inode(t, path) || cp.Offset != 3 {
+		t.Errorf("position in the new file = %+v, want inode %d, offset 3", cp, inode(t, path))
+	}
+}
+
+func TestTailerTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	appendFile(t, path, "")
+	tl := newTestTailer(t, path)
+	appendFile(t, path, "line one\nline two\n")
+	readString(t, tl, "line one\nline two\n")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "new\n")
+	readString(t, tl, "new\n")
+	if cp := tl.Position(int64(len("line one\nline two\nnew\n"))); cp.Inode != inode(t, path) || cp.Offset != 4 {
+		t.Errorf("position = %+v, want offset 4 after the truncation", cp)
+	}
+}
+
+func TestTailerResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	appendFile(t, path, "sent\nunsent\n")
+	fi, _ := os.Stat(path)
+	dev, ino := fileID(fi)
+
+	tl := newTestTailer(t, path)
+	if err := tl.Resume(Checkpoint{Device: dev, Inode: ino, Offset: 5}); err != nil {
+		t.Fatal(err)
+	}
+	readString(t, tl, "unsent\n")
+	if cp := tl.Position(6); cp.Inode != ino || cp.Offset != 11 {
+		t.Errorf("position = %+v, want offset 11", cp)
+	}
+}
+
+func TestTailerResumeRotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	appendFile(t, path, "sent\nunsent\n")
+	fi, _ := os.Stat(path)
+	dev, ino := fileID(fi)
+	cp := Checkpoint{Device: dev, Inode: ino, Offset: 5}
+
+	// rotated twice while the collector was down
+	if err := os.Rename(path, path+".2"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "middle\n")
+	appendFile(t, path, "live\n")
+	appendFile(t, path+".3", "older\n")
+
+	tl := newTestTailer(t, path)
+	if err := tl.Resume(cp); err != nil {
+		t.Fatal(err)
+	}
+	readString(t, tl, "unsent\nmiddle\nlive\n")
+	if got := tl.Position(3); got.Inode != ino || got.Offset != 8 {
+		t.Errorf("position in the checkpointed file = %+v, want inode %d, offset 8", got, ino)
+	}
+	if got := tl.Position(7 + 2); got.Inode != inode(t, path+".1") || got.Offset != 2 {
+		t.Errorf("position in the newer rotation = %+v, want inode %d, offset 2", got, inode(t, path+".1"))
+	}
+	if got := tl.Position(7 + 7 + 5); got.Inode != inode(t, path) || got.Offset != 5 {
+		t.Errorf("position in the live file = %+v, want inode %d, offset 5", got, inode(t, path))
+	}
+	appendFile(t, path, "more\n")
+	readString(t, tl, "more\n")
+}
+
+func TestTailerResumeGone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	appendFile(t, path, "everything\n")
+	tl := newTestTailer(t, path)
+	// the checkpointed file rotated out of reach; read what is there
+	if err := tl.Resume(Checkpoint{Inode: 1 << 40, Offset: 3}); err != nil {
+		t.Fatal(err)
+	}
+	readString(t, tl, "everything\n")
+}